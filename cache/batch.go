@@ -0,0 +1,63 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// MGet
+// reads keys in one call when cache implements BatchCache otherwise key by key,
+// missed keys are not present in the result
+func MGet(ctx context.Context, cache Cache, method string, keys ...string) (map[string]string, error) {
+	if bc, ok := cache.(BatchCache); ok {
+		return bc.MGet(ctx, method, keys...)
+	}
+
+	result := make(map[string]string, len(keys))
+	for _, key := range keys {
+		val, err := cache.GetKey(ctx, method, key)
+		if errors.Is(err, NotFoundError) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		result[key] = val
+	}
+
+	return result, nil
+}
+
+// MSet
+// stores items in one call when cache implements BatchCache otherwise key by key
+func MSet(ctx context.Context, cache Cache, method string, items map[string]string, expiration time.Duration) error {
+	if bc, ok := cache.(BatchCache); ok {
+		return bc.MSet(ctx, method, items, expiration)
+	}
+
+	for key, val := range items {
+		err := cache.Set(ctx, method, key, val, expiration)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MDel
+// removes keys in one call when cache implements BatchCache otherwise key by key
+func MDel(ctx context.Context, cache Cache, method string, keys ...string) error {
+	if bc, ok := cache.(BatchCache); ok {
+		return bc.MDel(ctx, method, keys...)
+	}
+
+	for _, key := range keys {
+		err := cache.RemoveKey(ctx, method, key)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,61 @@
+package cache
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// backends are expected to implement batch operations natively, MGet etc. would silently fall back otherwise
+var (
+	_ BatchCache = (*redisCache)(nil)
+	_ BatchCache = (*memCache)(nil)
+	_ BatchCache = (*shardedMemCache)(nil)
+)
+
+// plainCache hides the batch operations of the wrapped cache
+type plainCache struct {
+	Cache
+}
+
+func TestBatch(t *testing.T) {
+	srv := miniredis.RunT(t)
+	rd, err := NewRedisCache(WithAddresses(nil, srv.Addr()))
+	require.NoError(t, err)
+	cluster, err := NewRedisCache(WithClusterAddresses(srv.Addr()))
+	require.NoError(t, err)
+	mem, err := NewInMemoryCache(time.Second)
+	require.NoError(t, err)
+	sharded, err := NewInMemoryCache(time.Second, WithShards(4))
+	require.NoError(t, err)
+	fallbackMem, err := NewInMemoryCache(time.Second)
+	require.NoError(t, err)
+
+	caches := map[string]Cache{
+		"redis":    rd,
+		"cluster":  cluster,
+		"mem":      mem,
+		"sharded":  sharded,
+		"fallback": plainCache{fallbackMem},
+	}
+
+	for name, c := range caches {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			err := MSet(ctx, c, "nop", map[string]string{"p1": "v1", "p2": "v2", "p3": "v3"}, time.Minute)
+			require.NoError(t, err)
+
+			got, err := MGet(ctx, c, "nop", "p1", "missing", "p3")
+			require.NoError(t, err)
+			require.Equal(t, map[string]string{"p1": "v1", "p3": "v3"}, got)
+
+			require.NoError(t, MDel(ctx, c, "nop", "p1", "p2"))
+
+			got, err = MGet(ctx, c, "nop", "p1", "p2", "p3")
+			require.NoError(t, err)
+			require.Equal(t, map[string]string{"p3": "v3"}, got)
+		})
+	}
+}
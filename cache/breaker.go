@@ -0,0 +1,332 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"github.com/Electronic-Catalog/microkit/logger"
+	"github.com/Electronic-Catalog/microkit/logger/keyval"
+	"github.com/Electronic-Catalog/microkit/logger/zap"
+	"github.com/Electronic-Catalog/microkit/metric"
+	"sync"
+	"time"
+)
+
+// BreakerState
+// state of a circuit breaker
+type BreakerState string
+
+const (
+	// BreakerClosed sends calls to the primary cache
+	BreakerClosed BreakerState = "closed"
+	// BreakerOpen sends calls to the fallback cache until open timeout passes
+	BreakerOpen BreakerState = "open"
+	// BreakerHalfOpen lets a single probe call reach the primary cache, the others go to fallback
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// breakerStateOps are the ops which record changes of state in metrics
+var breakerStateOps = map[BreakerState]string{
+	BreakerClosed:   "close",
+	BreakerOpen:     "open",
+	BreakerHalfOpen: "half_open",
+}
+
+type breakerCache struct {
+	primary          Cache
+	fallback         Cache
+	ownFallback      bool
+	metric           metric.Metric
+	reqResLogger     logger.Logger
+	failureThreshold int
+	openTimeout      time.Duration
+	now              func() time.Time
+
+	lock     *sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// NewCircuitBreaker
+// wraps primary (usually a redis cache) with a circuit breaker, after failure threshold consecutive
+// failures (5 by default) the breaker opens and calls are served by an in-memory fallback cache instead of
+// waiting for timeouts of an unavailable primary. after open timeout (30s by default) a single probe call
+// reaches primary again, its success closes the breaker and its failure opens it for another open timeout.
+// writes during the open state only reach fallback, and the default fallback is flushed when the breaker
+// is closed, so it doesn't serve values which are changed on primary meanwhile
+func NewCircuitBreaker(primary Cache, options ...Option) (Cache, error) {
+	bc := breakerCache{
+		primary:          primary,
+		metric:           metric.NewNop(),
+		reqResLogger:     zap.NopLogger,
+		failureThreshold: 5,
+		openTimeout:      time.Second * 30,
+		now:              time.Now,
+		lock:             &sync.Mutex{},
+		state:            BreakerClosed,
+	}
+
+	for _, op := range options {
+		err := op(&bc)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if bc.fallback == nil {
+		fallback, err := NewInMemoryCache(time.Minute, WithMaxEntries(10000))
+		if err != nil {
+			return nil, err
+		}
+		bc.fallback = fallback
+		bc.ownFallback = true
+	}
+
+	return &bc, nil
+}
+
+// Ping
+// reports health of primary, it is not affected by the breaker
+func (b *breakerCache) Ping(ctx context.Context) error {
+	return b.primary.Ping(ctx)
+}
+
+func (b *breakerCache) GetKey(ctx context.Context, method string, key string) (string, error) {
+	var val string
+	err := b.execute(ctx, method, func(c Cache) error {
+		var err error
+		val, err = c.GetKey(ctx, method, key)
+		return err
+	})
+
+	return val, err
+}
+
+func (b *breakerCache) Set(ctx context.Context, method string, key string, val string, expiration time.Duration) error {
+	return b.execute(ctx, method, func(c Cache) error {
+		return c.Set(ctx, method, key, val, expiration)
+	})
+}
+
+func (b *breakerCache) RemoveKey(ctx context.Context, method string, key string) error {
+	return b.execute(ctx, method, func(c Cache) error {
+		return c.RemoveKey(ctx, method, key)
+	})
+}
+
+func (b *breakerCache) Incr(ctx context.Context, method string, key string) (int64, error) {
+	return b.IncrBy(ctx, method, key, 1)
+}
+
+func (b *breakerCache) IncrBy(ctx context.Context, method string, key string, delta int64) (int64, error) {
+	var val int64
+	err := b.execute(ctx, method, func(c Cache) error {
+		var err error
+		val, err = c.IncrBy(ctx, method, key, delta)
+		return err
+	})
+
+	return val, err
+}
+
+func (b *breakerCache) Decr(ctx context.Context, method string, key string) (int64, error) {
+	return b.IncrBy(ctx, method, key, -1)
+}
+
+func (b *breakerCache) SetNX(ctx context.Context, method string, key string, val string, expiration time.Duration) (bool, error) {
+	var stored bool
+	err := b.execute(ctx, method, func(c Cache) error {
+		var err error
+		stored, err = c.SetNX(ctx, method, key, val, expiration)
+		return err
+	})
+
+	return stored, err
+}
+
+func (b *breakerCache) TTL(ctx context.Context, method string, key string) (time.Duration, error) {
+	var ttl time.Duration
+	err := b.execute(ctx, method, func(c Cache) error {
+		var err error
+		ttl, err = c.TTL(ctx, method, key)
+		return err
+	})
+
+	return ttl, err
+}
+
+func (b *breakerCache) Expire(ctx context.Context, method string, key string, expiration time.Duration) error {
+	return b.execute(ctx, method, func(c Cache) error {
+		return c.Expire(ctx, method, key, expiration)
+	})
+}
+
+func (b *breakerCache) MGet(ctx context.Context, method string, keys ...string) (map[string]string, error) {
+	var vals map[string]string
+	err := b.execute(ctx, method, func(c Cache) error {
+		var err error
+		vals, err = MGet(ctx, c, method, keys...)
+		return err
+	})
+
+	return vals, err
+}
+
+func (b *breakerCache) MSet(ctx context.Context, method string, items map[string]string, expiration time.Duration) error {
+	return b.execute(ctx, method, func(c Cache) error {
+		return MSet(ctx, c, method, items, expiration)
+	})
+}
+
+func (b *breakerCache) MDel(ctx context.Context, method string, keys ...string) error {
+	return b.execute(ctx, method, func(c Cache) error {
+		return MDel(ctx, c, method, keys...)
+	})
+}
+
+// Close
+// closes primary and the default fallback, a fallback which is given by WithFallback is owned by the caller
+func (b *breakerCache) Close() error {
+	if b.ownFallback {
+		_ = b.fallback.Close()
+	}
+
+	return b.primary.Close()
+}
+
+// Unwrap
+// returns primary, so helpers which need a specific backend (e.g. RedisClient) see through the breaker
+func (b *breakerCache) Unwrap() Cache {
+	return b.primary
+}
+
+// execute
+// runs call on primary when the breaker allows it, otherwise or when primary fails it runs call on fallback
+func (b *breakerCache) execute(ctx context.Context, method string, call func(c Cache) error) error {
+	if !b.allow(method) {
+		return b.executeFallback(method, call)
+	}
+
+	err := call(b.primary)
+	switch {
+	case err == nil || isExpectedError(err) || isCallerError(err):
+		b.onSuccess(method)
+		return err
+	case errors.Is(err, context.Canceled) && ctx.Err() != nil:
+		// the caller gave up, it says nothing about health of primary
+		b.onCanceled()
+		return err
+	default:
+		b.onFailure(method, err)
+		return b.executeFallback(method, call)
+	}
+}
+
+// executeFallback runs call on fallback and records it as fallback op of the breaker
+func (b *breakerCache) executeFallback(method string, call func(c Cache) error) (err error) {
+	defer b.record("fallback", method, time.Now(), &err)
+
+	return call(b.fallback)
+}
+
+// record records an operation with the metric schema of backends, err points to the result of operation
+func (b *breakerCache) record(op string, method string, start time.Time, err *error) {
+	recordOperation(b.metric, "breaker", op, method, start, *err)
+}
+
+// allow
+// reports whether a call may reach primary, it moves an expired open breaker to half-open
+// and lets only one probe call through
+func (b *breakerCache) allow(method string) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if b.now().Sub(b.openedAt) < b.openTimeout {
+			return false
+		}
+		b.setState(BreakerHalfOpen, method, nil)
+		b.probing = true
+		return true
+	case BreakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+func (b *breakerCache) onSuccess(method string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.failures = 0
+	if b.state == BreakerHalfOpen {
+		b.probing = false
+		b.setState(BreakerClosed, method, nil)
+		if mc, ok := b.fallback.(*memCache); ok && b.ownFallback {
+			mc.flush()
+		}
+	}
+}
+
+func (b *breakerCache) onFailure(method string, err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	switch b.state {
+	case BreakerHalfOpen:
+		b.probing = false
+		b.openedAt = b.now()
+		b.setState(BreakerOpen, method, err)
+	case BreakerClosed:
+		b.failures++
+		if b.failures >= b.failureThreshold {
+			b.openedAt = b.now()
+			b.setState(BreakerOpen, method, err)
+		}
+	}
+}
+
+func (b *breakerCache) onCanceled() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.state == BreakerHalfOpen {
+		b.probing = false
+	}
+}
+
+// setState
+// changes state and reports it, method is the method of the call which changed it, the caller must hold the lock
+func (b *breakerCache) setState(state BreakerState, method string, err error) {
+	b.state = state
+	b.metric.IncrementTotal("breaker", breakerStateOps[state], method, OutcomeTransition)
+
+	switch state {
+	case BreakerOpen:
+		pairs := []keyval.Pair{
+			keyval.String("retry_after", b.openTimeout.String()),
+			keyval.Int("failures", b.failures),
+		}
+		if err != nil {
+			pairs = append(pairs, keyval.Error(err))
+		}
+		b.reqResLogger.Warn("cache circuit breaker opened, serving from fallback", pairs...)
+	case BreakerHalfOpen:
+		b.reqResLogger.Info("cache circuit breaker half-open, probing primary")
+	case BreakerClosed:
+		b.reqResLogger.Info("cache circuit breaker closed, primary recovered")
+	}
+}
+
+// isCallerError reports errors which are caused by the caller rather than an unhealthy backend
+func isCallerError(err error) bool {
+	var decodeErr *DecodeError
+	return errors.Is(err, NotIntegerError) || errors.As(err, &decodeErr)
+}
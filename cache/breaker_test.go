@@ -0,0 +1,139 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"github.com/Electronic-Catalog/microkit/metric/metrictest"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/require"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// switchableCache fails GetKey and Set while it is down
+type switchableCache struct {
+	Cache
+	down  atomic.Bool
+	calls atomic.Int32
+}
+
+func (s *switchableCache) GetKey(ctx context.Context, method string, key string) (string, error) {
+	s.calls.Add(1)
+	if s.down.Load() {
+		return "", errors.New("dial tcp: connection refused")
+	}
+	return s.Cache.GetKey(ctx, method, key)
+}
+
+func (s *switchableCache) Set(ctx context.Context, method string, key string, val string, expiration time.Duration) error {
+	s.calls.Add(1)
+	if s.down.Load() {
+		return errors.New("dial tcp: connection refused")
+	}
+	return s.Cache.Set(ctx, method, key, val, expiration)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+	primary := &switchableCache{Cache: newMemForTest(t)}
+	mt := metrictest.NewRecorder()
+	logs := &recordingLogger{messages: map[string][]string{}}
+	c, err := NewCircuitBreaker(primary, WithFailureThreshold(3), WithOpenTimeout(time.Minute),
+		WithMetricOption(mt), WithLoggerOption(logs))
+	require.NoError(t, err)
+	defer c.Close()
+	bc := c.(*breakerCache)
+	clock := newFakeClock()
+	bc.now = clock.now
+
+	require.NoError(t, c.Set(ctx, "nop", "p1", "from-primary", time.Minute))
+	_, err = c.GetKey(ctx, "nop", "missing")
+	require.ErrorIs(t, err, NotFoundError)
+
+	// failed calls are served by fallback, the third one opens the breaker
+	primary.down.Store(true)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, c.Set(ctx, "nop", "p2", "from-fallback", time.Minute))
+	}
+	require.Equal(t, BreakerOpen, bc.state)
+	require.Equal(t, 1, mt.Total("breaker", "open", "nop", OutcomeTransition))
+	require.Len(t, logs.messages["warn"], 1)
+
+	// an open breaker doesn't call primary
+	primary.calls.Store(0)
+	val, err := c.GetKey(ctx, "nop", "p2")
+	require.NoError(t, err)
+	require.Equal(t, "from-fallback", val)
+	require.Equal(t, int32(0), primary.calls.Load())
+	require.Equal(t, 4, mt.Total("breaker", "fallback", "nop", OutcomeHit))
+
+	// a failed probe opens the breaker again
+	clock.advance(time.Minute)
+	_, _ = c.GetKey(ctx, "nop", "p2")
+	require.Equal(t, int32(1), primary.calls.Load())
+	require.Equal(t, BreakerOpen, bc.state)
+	_, _ = c.GetKey(ctx, "nop", "p2")
+	require.Equal(t, int32(1), primary.calls.Load())
+
+	// a successful probe closes it and drops values of fallback
+	primary.down.Store(false)
+	clock.advance(time.Minute)
+	val, err = c.GetKey(ctx, "nop", "p1")
+	require.NoError(t, err)
+	require.Equal(t, "from-primary", val)
+	require.Equal(t, BreakerClosed, bc.state)
+	require.Equal(t, 2, mt.Total("breaker", "half_open", "nop", OutcomeTransition))
+	_, err = bc.fallback.GetKey(ctx, "nop", "p2")
+	require.ErrorIs(t, err, NotFoundError)
+}
+
+func TestCircuitBreakerHalfOpenSingleProbe(t *testing.T) {
+	ctx := context.Background()
+	primary := &switchableCache{Cache: newMemForTest(t)}
+	c, err := NewCircuitBreaker(primary, WithFailureThreshold(1), WithOpenTimeout(time.Minute))
+	require.NoError(t, err)
+	defer c.Close()
+	bc := c.(*breakerCache)
+	clock := newFakeClock()
+	bc.now = clock.now
+
+	primary.down.Store(true)
+	_, _ = c.GetKey(ctx, "nop", "p1")
+	require.Equal(t, BreakerOpen, bc.state)
+
+	clock.advance(time.Minute)
+	require.True(t, bc.allow("nop"))
+	require.Equal(t, BreakerHalfOpen, bc.state)
+	// the probe is in flight, others go to fallback
+	require.False(t, bc.allow("nop"))
+
+	// a probe which is canceled by its caller frees the probe slot
+	bc.onCanceled()
+	require.True(t, bc.allow("nop"))
+}
+
+func TestCircuitBreakerRedis(t *testing.T) {
+	srv := miniredis.RunT(t)
+	rd, err := NewRedisCache(WithAddresses(nil, srv.Addr()), WithMaxRetry(-1))
+	require.NoError(t, err)
+	c, err := NewCircuitBreaker(rd, WithFailureThreshold(1))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	_, err = RedisClient(c)
+	require.NoError(t, err)
+
+	srv.Close()
+	require.NoError(t, c.Set(ctx, "nop", "p1", "v1", time.Minute))
+	require.Equal(t, BreakerOpen, c.(*breakerCache).state)
+
+	start := time.Now()
+	val, err := c.GetKey(ctx, "nop", "p1")
+	require.NoError(t, err)
+	require.Equal(t, "v1", val)
+	require.Less(t, time.Since(start), time.Millisecond*100)
+
+	_, err = NewCircuitBreaker(rd, WithFailureThreshold(0))
+	require.Error(t, err)
+}
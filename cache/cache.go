@@ -5,6 +5,10 @@ import (
 	"time"
 )
 
+// NoExpiration
+// is returned by TTL for keys which never expire
+const NoExpiration time.Duration = -1
+
 // Cache
 // this interface give us an abstraction over internal cache we are using
 // currently the cache repo contains in-memory cache and redis cache which
@@ -30,4 +34,70 @@ type Cache interface {
 	// method :: used for metrics
 	// key :: the item which you wish to remove from the cache
 	RemoveKey(ctx context.Context, method string, key string) error
+
+	// Incr
+	// to increment integer value of key by one atomically, a missed key is counted from zero
+	// method :: used for metrics
+	Incr(ctx context.Context, method string, key string) (int64, error)
+	// IncrBy
+	// to increment integer value of key by delta atomically, expiration of an existing key is kept
+	// method :: used for metrics
+	IncrBy(ctx context.Context, method string, key string, delta int64) (int64, error)
+	// Decr
+	// to decrement integer value of key by one atomically
+	// method :: used for metrics
+	Decr(ctx context.Context, method string, key string) (int64, error)
+	// SetNX
+	// to store specified item only if key does not exist, it reports whether the item is stored,
+	// so only the first of concurrent writers wins
+	// method :: used for metrics
+	SetNX(ctx context.Context, method string, key string, val string, expiration time.Duration) (bool, error)
+	// TTL
+	// to read remaining time to live of key, NoExpiration is returned for keys without expiration
+	// and NotFoundError for missed keys
+	// method :: used for metrics
+	TTL(ctx context.Context, method string, key string) (time.Duration, error)
+	// Expire
+	// to change expiration of an existing key, expiration less than or equal to zero removes it,
+	// NotFoundError is returned for missed keys
+	// method :: used for metrics
+	Expire(ctx context.Context, method string, key string, expiration time.Duration) error
+
+	// Close
+	// to release connections and stop background processes of the cache
+	Close() error
+}
+
+// BatchCache
+// is implemented by backends which are able to read, write and remove several keys at once,
+// use MGet, MSet and MDel functions to fall back to single key operations on other backends
+type BatchCache interface {
+	Cache
+	// MGet
+	// to read several keys at once, missed keys are not present in the result
+	// method :: used for metrics, recorded once per batch
+	MGet(ctx context.Context, method string, keys ...string) (map[string]string, error)
+	// MSet
+	// to store all items with the same expiration
+	// method :: used for metrics, recorded once per batch
+	MSet(ctx context.Context, method string, items map[string]string, expiration time.Duration) error
+	// MDel
+	// to remove several keys at once
+	// method :: used for metrics, recorded once per batch
+	MDel(ctx context.Context, method string, keys ...string) error
+}
+
+// TaggedCache
+// is implemented by backends which are able to group keys by tags (e.g. "category:42")
+// and remove every key of a tag at once
+type TaggedCache interface {
+	Cache
+	// SetWithTags
+	// to store specified item like Set and attach tags to it
+	// method :: used for metrics
+	SetWithTags(ctx context.Context, method string, key string, val string, expiration time.Duration, tags ...string) error
+	// InvalidateTags
+	// to remove every key which is attached to any of tags
+	// method :: used for metrics
+	InvalidateTags(ctx context.Context, method string, tags ...string) error
 }
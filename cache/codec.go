@@ -0,0 +1,169 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"reflect"
+)
+
+// Codec
+// converts values to and from the raw representation which is stored in cache
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// Compressor
+// compresses encoded values before storing them in cache and decompresses them after reading
+type Compressor interface {
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+type jsonCodec struct{}
+
+// NewJSONCodec
+// encodes values with encoding/json
+func NewJSONCodec() Codec {
+	return jsonCodec{}
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type gobCodec struct{}
+
+// NewGobCodec
+// encodes values with encoding/gob, interface typed fields have to be registered with gob.Register
+func NewGobCodec() Codec {
+	return gobCodec{}
+}
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type msgpackCodec struct{}
+
+// NewMsgpackCodec
+// encodes values with MessagePack which is usually smaller and faster than json
+func NewMsgpackCodec() Codec {
+	return msgpackCodec{}
+}
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+type protoCodec struct{}
+
+// NewProtoCodec
+// encodes protobuf messages, the cached type has to be a proto.Message (e.g. *pb.Product)
+func NewProtoCodec() Codec {
+	return protoCodec{}
+}
+
+func (protoCodec) Marshal(v interface{}) ([]byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("proto codec: %T is not a proto.Message", v)
+	}
+
+	return proto.Marshal(msg)
+}
+
+func (protoCodec) Unmarshal(data []byte, v interface{}) error {
+	if msg, ok := v.(proto.Message); ok {
+		return proto.Unmarshal(data, msg)
+	}
+
+	// typed cache passes a pointer to its value, and for messages the value itself is a
+	// (probably nil) pointer, so we have to allocate it before unmarshalling
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Ptr {
+		return fmt.Errorf("proto codec: %T is not a proto.Message", v)
+	}
+
+	elem := rv.Elem()
+	if elem.IsNil() {
+		elem.Set(reflect.New(elem.Type().Elem()))
+	}
+
+	msg, ok := elem.Interface().(proto.Message)
+	if !ok {
+		return fmt.Errorf("proto codec: %T is not a proto.Message", elem.Interface())
+	}
+
+	return proto.Unmarshal(data, msg)
+}
+
+type gzipCompressor struct {
+	level int
+}
+
+// NewGzipCompressor
+// level is one of compress/gzip levels, e.g. gzip.DefaultCompression or gzip.BestSpeed
+func NewGzipCompressor(level int) (Compressor, error) {
+	// validate level once here instead of failing on every write
+	_, err := gzip.NewWriterLevel(io.Discard, level)
+	if err != nil {
+		return nil, err
+	}
+
+	return gzipCompressor{level: level}, nil
+}
+
+func (g gzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	gw, err := gzip.NewWriterLevel(&buf, g.level)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = gw.Write(data)
+	if err != nil {
+		return nil, err
+	}
+
+	err = gw.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (g gzipCompressor) Decompress(data []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	return io.ReadAll(zr)
+}
@@ -0,0 +1,240 @@
+package cache
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseConnectionString
+// parses a connection string of WithConnectionStringOption, sentinel urls are returned as failover options
+// and the others as single instance options
+func parseConnectionString(connectionString string) (*redis.Options, *redis.FailoverOptions, error) {
+	u, err := url.Parse(connectionString)
+	if err != nil {
+		return nil, nil, fmt.Errorf("got error %v on configuring redis with connection string", err)
+	}
+
+	// settings of both modes are parsed into failover options, they are a superset of single instance options
+	option := &redis.FailoverOptions{
+		Username: u.User.Username(),
+	}
+	option.Password, _ = u.User.Password()
+
+	useTLS := false
+	switch u.Scheme {
+	case "redis":
+	case "rediss":
+		useTLS = true
+	case "redis-sentinel":
+	default:
+		return nil, nil, fmt.Errorf("unsupported redis connection string scheme %q, use redis, rediss or redis-sentinel", u.Scheme)
+	}
+
+	addrs := strings.Split(u.Host, ",")
+	for _, addr := range addrs {
+		if addr == "" {
+			return nil, nil, fmt.Errorf("redis connection string has an empty address")
+		}
+	}
+	if u.Scheme != "redis-sentinel" && len(addrs) > 1 {
+		return nil, nil, fmt.Errorf("%s connection string accepts one address, use redis-sentinel for several sentinels", u.Scheme)
+	}
+
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		option.DB, err = strconv.Atoi(db)
+		if err != nil || option.DB < 0 {
+			return nil, nil, fmt.Errorf("invalid db %q in redis connection string", db)
+		}
+	}
+
+	tlsConfig, err := parseConnectionParams(u.Query(), option, &useTLS)
+	if err != nil {
+		return nil, nil, err
+	}
+	if useTLS {
+		// go-redis doesn't derive server name from the address, sentinel mode needs tls_server_name or tls_skip_verify
+		if tlsConfig.ServerName == "" && u.Scheme != "redis-sentinel" {
+			tlsConfig.ServerName = u.Hostname()
+		}
+		if tlsConfig.ServerName == "" && !tlsConfig.InsecureSkipVerify {
+			return nil, nil, fmt.Errorf("tls of redis-sentinel connection string requires tls_server_name or tls_skip_verify")
+		}
+		option.TLSConfig = tlsConfig
+	}
+
+	if u.Scheme == "redis-sentinel" {
+		if option.MasterName == "" {
+			return nil, nil, fmt.Errorf("redis-sentinel connection string requires master parameter")
+		}
+		option.SentinelAddrs = addrs
+		return nil, option, nil
+	}
+	if option.MasterName != "" || option.SentinelPassword != "" {
+		return nil, nil, fmt.Errorf("master and sentinel_password parameters are only supported by redis-sentinel connection strings")
+	}
+
+	return &redis.Options{
+		Addr:            addrs[0],
+		Username:        option.Username,
+		Password:        option.Password,
+		DB:              option.DB,
+		MaxRetries:      option.MaxRetries,
+		MinRetryBackoff: option.MinRetryBackoff,
+		MaxRetryBackoff: option.MaxRetryBackoff,
+		DialTimeout:     option.DialTimeout,
+		ReadTimeout:     option.ReadTimeout,
+		WriteTimeout:    option.WriteTimeout,
+		PoolSize:        option.PoolSize,
+		MinIdleConns:    option.MinIdleConns,
+		MaxConnAge:      option.MaxConnAge,
+		PoolTimeout:     option.PoolTimeout,
+		IdleTimeout:     option.IdleTimeout,
+		TLSConfig:       option.TLSConfig,
+	}, nil, nil
+}
+
+// parseConnectionParams
+// applies query parameters to option and returns the tls config which is built from tls parameters,
+// tls=true enables tls for schemes other than rediss (e.g. sentinels behind tls)
+func parseConnectionParams(query url.Values, option *redis.FailoverOptions, useTLS *bool) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	var certFile, keyFile string
+	tlsParams := false
+
+	for name, values := range query {
+		if len(values) != 1 {
+			return nil, fmt.Errorf("parameter %s of redis connection string is given %d times", name, len(values))
+		}
+		value := values[0]
+
+		var err error
+		if strings.HasPrefix(name, "tls_") {
+			tlsParams = true
+		}
+		switch name {
+		case "master":
+			option.MasterName = value
+		case "sentinel_username":
+			option.SentinelUsername = value
+		case "sentinel_password":
+			option.SentinelPassword = value
+		case "pool_size":
+			option.PoolSize, err = parsePositiveInt(value)
+		case "min_idle_conns":
+			option.MinIdleConns, err = parsePositiveInt(value)
+		case "max_retries":
+			// -1 disables retries like go-redis
+			option.MaxRetries, err = strconv.Atoi(value)
+			if err == nil && option.MaxRetries < -1 {
+				err = fmt.Errorf("must not be less than -1")
+			}
+		case "min_retry_backoff":
+			option.MinRetryBackoff, err = parseConnectionDuration(value)
+		case "max_retry_backoff":
+			option.MaxRetryBackoff, err = parseConnectionDuration(value)
+		case "dial_timeout":
+			option.DialTimeout, err = parseConnectionDuration(value)
+		case "read_timeout":
+			option.ReadTimeout, err = parseConnectionDuration(value)
+		case "write_timeout":
+			option.WriteTimeout, err = parseConnectionDuration(value)
+		case "pool_timeout":
+			option.PoolTimeout, err = parseConnectionDuration(value)
+		case "idle_timeout":
+			option.IdleTimeout, err = parseConnectionDuration(value)
+		case "max_conn_age":
+			option.MaxConnAge, err = parseConnectionDuration(value)
+		case "tls":
+			*useTLS, err = strconv.ParseBool(value)
+		case "tls_skip_verify":
+			tlsConfig.InsecureSkipVerify, err = strconv.ParseBool(value)
+		case "tls_server_name":
+			tlsConfig.ServerName = value
+		case "tls_ca":
+			tlsConfig.RootCAs, err = loadCertPool(value)
+		case "tls_cert":
+			certFile = value
+		case "tls_key":
+			keyFile = value
+		default:
+			return nil, fmt.Errorf("unknown parameter %s in redis connection string", name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q in redis connection string: %w", name, value, err)
+		}
+	}
+
+	if tlsParams && !*useTLS {
+		return nil, fmt.Errorf("tls parameters of redis connection string require rediss scheme or tls=true")
+	}
+	if (certFile == "") != (keyFile == "") {
+		return nil, fmt.Errorf("tls_cert and tls_key of redis connection string must be given together")
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load redis client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+func parsePositiveInt(value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if n <= 0 {
+		return 0, fmt.Errorf("must be positive")
+	}
+
+	return n, nil
+}
+
+// parseConnectionDuration
+// accepts go durations (e.g. 500ms) and plain numbers as seconds, -1 disables timeouts like go-redis
+func parseConnectionDuration(value string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < -1 {
+			return 0, fmt.Errorf("must not be less than -1")
+		}
+		if seconds == -1 {
+			return -1, nil
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("must not be negative")
+	}
+
+	return d, nil
+}
+
+// loadCertPool reads PEM encoded certificates of a CA file
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate is found")
+	}
+
+	return pool, nil
+}
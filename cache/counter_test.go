@@ -0,0 +1,141 @@
+package cache
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/require"
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCounterAndExpiry(t *testing.T) {
+	srv := miniredis.RunT(t)
+	rd, err := NewRedisCache(WithAddresses(nil, srv.Addr()))
+	require.NoError(t, err)
+	mem, err := NewInMemoryCache(time.Second)
+	require.NoError(t, err)
+	sharded, err := NewInMemoryCache(time.Second, WithShards(4))
+	require.NoError(t, err)
+	near, err := NewNearCache(rd)
+	require.NoError(t, err)
+	defer near.Close()
+
+	caches := map[string]Cache{
+		"redis":   rd,
+		"mem":     mem,
+		"sharded": sharded,
+		"near":    near,
+	}
+
+	for name, c := range caches {
+		t.Run(name, func(t *testing.T) {
+			srv.FlushAll()
+			ctx := context.Background()
+
+			val, err := c.Incr(ctx, "nop", "views:1")
+			require.NoError(t, err)
+			require.Equal(t, int64(1), val)
+			val, err = c.IncrBy(ctx, "nop", "views:1", 10)
+			require.NoError(t, err)
+			require.Equal(t, int64(11), val)
+			val, err = c.Decr(ctx, "nop", "views:1")
+			require.NoError(t, err)
+			require.Equal(t, int64(10), val)
+			got, err := c.GetKey(ctx, "nop", "views:1")
+			require.NoError(t, err)
+			require.Equal(t, "10", got)
+
+			ttl, err := c.TTL(ctx, "nop", "views:1")
+			require.NoError(t, err)
+			require.Equal(t, NoExpiration, ttl)
+			_, err = c.TTL(ctx, "nop", "unknown")
+			require.ErrorIs(t, err, NotFoundError)
+
+			// increment keeps expiration of counter
+			require.NoError(t, c.Expire(ctx, "nop", "views:1", time.Minute))
+			_, err = c.Incr(ctx, "nop", "views:1")
+			require.NoError(t, err)
+			ttl, err = c.TTL(ctx, "nop", "views:1")
+			require.NoError(t, err)
+			require.Greater(t, ttl, time.Second*50)
+			require.LessOrEqual(t, ttl, time.Minute)
+
+			require.NoError(t, c.Expire(ctx, "nop", "views:1", 0))
+			ttl, err = c.TTL(ctx, "nop", "views:1")
+			require.NoError(t, err)
+			require.Equal(t, NoExpiration, ttl)
+			require.ErrorIs(t, c.Expire(ctx, "nop", "unknown", time.Minute), NotFoundError)
+
+			require.NoError(t, c.Set(ctx, "nop", "name", "microkit", time.Minute))
+			_, err = c.Incr(ctx, "nop", "name")
+			require.ErrorIs(t, err, NotIntegerError)
+			require.NoError(t, c.Set(ctx, "nop", "max", "9223372036854775807", time.Minute))
+			_, err = c.IncrBy(ctx, "nop", "max", 1)
+			require.ErrorIs(t, err, NotIntegerError)
+
+			stored, err := c.SetNX(ctx, "nop", "owner", "replica-1", time.Minute)
+			require.NoError(t, err)
+			require.True(t, stored)
+			stored, err = c.SetNX(ctx, "nop", "owner", "replica-2", time.Minute)
+			require.NoError(t, err)
+			require.False(t, stored)
+			got, err = c.GetKey(ctx, "nop", "owner")
+			require.NoError(t, err)
+			require.Equal(t, "replica-1", got)
+		})
+	}
+}
+
+func TestMemCacheConcurrentIncr(t *testing.T) {
+	mem, err := NewInMemoryCache(time.Second)
+	require.NoError(t, err)
+	defer mem.Close()
+	ctx := context.Background()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_, _ = mem.Incr(ctx, "nop", "views:1")
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := mem.GetKey(ctx, "nop", "views:1")
+	require.NoError(t, err)
+	require.Equal(t, "5000", got)
+}
+
+func TestMemCacheExpiredCounter(t *testing.T) {
+	mem, err := NewInMemoryCache(time.Minute)
+	require.NoError(t, err)
+	defer mem.Close()
+	ctx := context.Background()
+
+	require.NoError(t, mem.Set(ctx, "nop", "views:1", "41", time.Millisecond*10))
+	require.NoError(t, mem.Set(ctx, "nop", "owner", "replica-1", time.Millisecond*10))
+	time.Sleep(time.Millisecond * 20)
+
+	// expired items which are not swept yet are treated as missed
+	val, err := mem.Incr(ctx, "nop", "views:1")
+	require.NoError(t, err)
+	require.Equal(t, int64(1), val)
+	stored, err := mem.SetNX(ctx, "nop", "owner", "replica-2", 0)
+	require.NoError(t, err)
+	require.True(t, stored)
+	ttl, err := mem.TTL(ctx, "nop", "owner")
+	require.NoError(t, err)
+	require.Equal(t, NoExpiration, ttl)
+
+	_, err = mem.IncrBy(ctx, "nop", "views:1", math.MinInt64)
+	require.NoError(t, err)
+	_, err = mem.Decr(ctx, "nop", "views:1")
+	require.NoError(t, err)
+	_, err = mem.Decr(ctx, "nop", "views:1")
+	require.ErrorIs(t, err, NotIntegerError)
+}
@@ -1,9 +1,36 @@
 package cache
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 type Error error
 
 var (
-	NotFoundError Error = errors.New("key not-found")
+	NotFoundError        Error = errors.New("key not-found")
+	LockNotAcquiredError Error = errors.New("lock is held by another owner")
+	LockNotHeldError     Error = errors.New("lock is not held by this owner")
+	NotIntegerError      Error = errors.New("value is not an integer or out of range")
+	// KnownMissingError is returned for keys which are cached as missing in the source of truth,
+	// unlike NotFoundError there is no need to ask the source of truth again
+	KnownMissingError Error = errors.New("key is known to be missing")
+	// InvalidSnapshotError is returned when a cache snapshot is corrupted or written by an unsupported version
+	InvalidSnapshotError Error = errors.New("invalid cache snapshot")
 )
+
+// DecodeError
+// is returned when a cached value exists but can not be decoded into the requested type,
+// callers can distinguish it from NotFoundError with errors.As
+type DecodeError struct {
+	Key string
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("could not decode cached value of key %q: %v", e.Key, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,357 @@
+package cache
+
+import (
+	"container/heap"
+	"container/list"
+	"fmt"
+	"hash/maphash"
+)
+
+// EvictionPolicy
+// decides which key of a bounded in-memory cache is removed when it exceeds its limits
+type EvictionPolicy string
+
+const (
+	// LRU evicts the least recently used key
+	LRU EvictionPolicy = "lru"
+	// LFU evicts the least frequently used key, ties are broken by recency
+	LFU EvictionPolicy = "lfu"
+	// WTinyLFU keeps new keys in a small LRU window and admits them to the main segmented LRU
+	// only when they are used more often than the key they would replace, it resists scans
+	WTinyLFU EvictionPolicy = "w-tinylfu"
+)
+
+// evictionPolicy
+// tracks keys of memCache, it is always called while memCache is write locked
+type evictionPolicy interface {
+	add(key string)
+	touch(key string)
+	remove(key string)
+	victim() (string, bool)
+}
+
+func newEvictionPolicy(policy EvictionPolicy, capacity int) (evictionPolicy, error) {
+	switch policy {
+	case LRU:
+		return newLRUPolicy(), nil
+	case LFU:
+		return newLFUPolicy(), nil
+	case WTinyLFU:
+		return newTinyLFUPolicy(capacity), nil
+	default:
+		return nil, fmt.Errorf("unknown eviction policy %q", policy)
+	}
+}
+
+type lruPolicy struct {
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+func newLRUPolicy() *lruPolicy {
+	return &lruPolicy{
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (l *lruPolicy) add(key string) {
+	l.entries[key] = l.order.PushFront(key)
+}
+
+func (l *lruPolicy) touch(key string) {
+	if el, ok := l.entries[key]; ok {
+		l.order.MoveToFront(el)
+	}
+}
+
+func (l *lruPolicy) remove(key string) {
+	if el, ok := l.entries[key]; ok {
+		l.order.Remove(el)
+		delete(l.entries, key)
+	}
+}
+
+func (l *lruPolicy) victim() (string, bool) {
+	el := l.order.Back()
+	if el == nil {
+		return "", false
+	}
+
+	return el.Value.(string), true
+}
+
+type lfuEntry struct {
+	key       string
+	frequency uint64
+	lastUsed  uint64
+	index     int
+}
+
+// lfuHeap is a min-heap of entries ordered by frequency and then by last use
+type lfuHeap []*lfuEntry
+
+func (h lfuHeap) Len() int { return len(h) }
+
+func (h lfuHeap) Less(i, j int) bool {
+	if h[i].frequency == h[j].frequency {
+		return h[i].lastUsed < h[j].lastUsed
+	}
+	return h[i].frequency < h[j].frequency
+}
+
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *lfuHeap) Push(x interface{}) {
+	e := x.(*lfuEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *lfuHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return e
+}
+
+type lfuPolicy struct {
+	heap    lfuHeap
+	entries map[string]*lfuEntry
+	clock   uint64
+}
+
+func newLFUPolicy() *lfuPolicy {
+	return &lfuPolicy{
+		entries: make(map[string]*lfuEntry),
+	}
+}
+
+func (l *lfuPolicy) add(key string) {
+	l.clock++
+	e := &lfuEntry{key: key, frequency: 1, lastUsed: l.clock}
+	l.entries[key] = e
+	heap.Push(&l.heap, e)
+}
+
+func (l *lfuPolicy) touch(key string) {
+	if e, ok := l.entries[key]; ok {
+		l.clock++
+		e.frequency++
+		e.lastUsed = l.clock
+		heap.Fix(&l.heap, e.index)
+	}
+}
+
+func (l *lfuPolicy) remove(key string) {
+	if e, ok := l.entries[key]; ok {
+		heap.Remove(&l.heap, e.index)
+		delete(l.entries, key)
+	}
+}
+
+func (l *lfuPolicy) victim() (string, bool) {
+	if len(l.heap) == 0 {
+		return "", false
+	}
+
+	return l.heap[0].key, true
+}
+
+type segment int
+
+const (
+	windowSegment segment = iota
+	probationSegment
+	protectedSegment
+)
+
+type tinyLFUEntry struct {
+	key     string
+	segment segment
+	// candidate is set for keys which just left the window and are not admitted to main yet
+	candidate bool
+}
+
+// tinyLFUPolicy
+// is W-TinyLFU, a window LRU (1% of keys) in front of a segmented LRU (probation and protected 80%),
+// a key leaving the window is a candidate and on eviction it competes with the probation victim
+// on their estimated frequency, so one-hit wonders of a scan don't wash out frequently used keys
+type tinyLFUPolicy struct {
+	window    *list.List
+	probation *list.List
+	protected *list.List
+	entries   map[string]*list.Element
+	sketch    *countMinSketch
+}
+
+func newTinyLFUPolicy(capacity int) *tinyLFUPolicy {
+	return &tinyLFUPolicy{
+		window:    list.New(),
+		probation: list.New(),
+		protected: list.New(),
+		entries:   make(map[string]*list.Element),
+		sketch:    newCountMinSketch(capacity),
+	}
+}
+
+func (t *tinyLFUPolicy) segmentList(s segment) *list.List {
+	switch s {
+	case windowSegment:
+		return t.window
+	case probationSegment:
+		return t.probation
+	default:
+		return t.protected
+	}
+}
+
+func (t *tinyLFUPolicy) add(key string) {
+	t.sketch.increment(key)
+	t.entries[key] = t.window.PushFront(&tinyLFUEntry{key: key, segment: windowSegment})
+
+	windowCap := len(t.entries) / 100
+	if windowCap < 1 {
+		windowCap = 1
+	}
+	for t.window.Len() > windowCap {
+		el := t.window.Back()
+		e := el.Value.(*tinyLFUEntry)
+		t.window.Remove(el)
+		e.segment = probationSegment
+		e.candidate = true
+		t.entries[e.key] = t.probation.PushFront(e)
+	}
+}
+
+func (t *tinyLFUPolicy) touch(key string) {
+	el, ok := t.entries[key]
+	if !ok {
+		return
+	}
+	t.sketch.increment(key)
+
+	e := el.Value.(*tinyLFUEntry)
+	e.candidate = false
+	switch e.segment {
+	case windowSegment:
+		t.window.MoveToFront(el)
+	case protectedSegment:
+		t.protected.MoveToFront(el)
+	case probationSegment:
+		t.probation.Remove(el)
+		e.segment = protectedSegment
+		t.entries[key] = t.protected.PushFront(e)
+
+		protectedCap := (t.probation.Len() + t.protected.Len()) * 8 / 10
+		for t.protected.Len() > protectedCap && t.protected.Len() > 1 {
+			last := t.protected.Back()
+			demoted := last.Value.(*tinyLFUEntry)
+			t.protected.Remove(last)
+			demoted.segment = probationSegment
+			t.entries[demoted.key] = t.probation.PushFront(demoted)
+		}
+	}
+}
+
+func (t *tinyLFUPolicy) remove(key string) {
+	if el, ok := t.entries[key]; ok {
+		e := el.Value.(*tinyLFUEntry)
+		t.segmentList(e.segment).Remove(el)
+		delete(t.entries, key)
+	}
+}
+
+func (t *tinyLFUPolicy) victim() (string, bool) {
+	if front, back := t.probation.Front(), t.probation.Back(); front != nil && front != back {
+		candidate := front.Value.(*tinyLFUEntry)
+		if candidate.candidate {
+			// admission: the newcomer replaces the probation victim only when it is used more often
+			victim := back.Value.(*tinyLFUEntry)
+			if t.sketch.estimate(candidate.key) > t.sketch.estimate(victim.key) {
+				candidate.candidate = false
+				return victim.key, true
+			}
+			return candidate.key, true
+		}
+	}
+
+	for _, l := range []*list.List{t.probation, t.protected, t.window} {
+		if el := l.Back(); el != nil {
+			return el.Value.(*tinyLFUEntry).key, true
+		}
+	}
+
+	return "", false
+}
+
+// countMinSketch
+// estimates key frequencies with 4 rows of saturating counters, counters are halved
+// periodically so old popularity fades away
+type countMinSketch struct {
+	rows      [4][]uint8
+	seeds     [4]maphash.Seed
+	mask      uint64
+	additions int
+	resetAt   int
+}
+
+func newCountMinSketch(capacity int) *countMinSketch {
+	// wide enough to keep collisions low when a scan brings many more keys than capacity
+	width := 1024
+	for width < capacity*2 {
+		width <<= 1
+	}
+
+	s := countMinSketch{
+		mask:    uint64(width - 1),
+		resetAt: width * 10,
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+		s.seeds[i] = maphash.MakeSeed()
+	}
+
+	return &s
+}
+
+func (s *countMinSketch) increment(key string) {
+	for i := range s.rows {
+		idx := maphash.String(s.seeds[i], key) & s.mask
+		if s.rows[i][idx] < 15 {
+			s.rows[i][idx]++
+		}
+	}
+
+	s.additions++
+	if s.additions >= s.resetAt {
+		s.reset()
+	}
+}
+
+func (s *countMinSketch) estimate(key string) uint8 {
+	min := uint8(15)
+	for i := range s.rows {
+		idx := maphash.String(s.seeds[i], key) & s.mask
+		if s.rows[i][idx] < min {
+			min = s.rows[i][idx]
+		}
+	}
+
+	return min
+}
+
+func (s *countMinSketch) reset() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+	s.additions /= 2
+}
@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"container/heap"
+	"time"
+)
+
+// expiryHeap
+// is a min-heap of items ordered by expiration, items without expiration are never pushed
+type expiryHeap []*item
+
+func (h expiryHeap) Len() int { return len(h) }
+
+func (h expiryHeap) Less(i, j int) bool {
+	return h[i].expiration.Before(h[j].expiration)
+}
+
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *expiryHeap) Push(x interface{}) {
+	it := x.(*item)
+	it.index = len(*h)
+	*h = append(*h, it)
+}
+
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	it := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	it.index = -1
+	return it
+}
+
+// track
+// adds, moves or removes it in heap based on its expiration
+func (h *expiryHeap) track(it *item) {
+	switch {
+	case it.expiration.IsZero() && it.index >= 0:
+		heap.Remove(h, it.index)
+	case it.expiration.IsZero():
+		// never expires
+	case it.index >= 0:
+		heap.Fix(h, it.index)
+	default:
+		heap.Push(h, it)
+	}
+}
+
+func (h *expiryHeap) untrack(it *item) {
+	if it.index >= 0 {
+		heap.Remove(h, it.index)
+	}
+}
+
+// peekExpired
+// returns the item which expires first when it is expired at now
+func (h expiryHeap) peekExpired(now time.Time) (*item, bool) {
+	if len(h) == 0 || !h[0].expired(now) {
+		return nil, false
+	}
+
+	return h[0], true
+}
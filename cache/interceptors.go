@@ -0,0 +1,124 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"github.com/Electronic-Catalog/microkit/logger"
+	"github.com/Electronic-Catalog/microkit/logger/keyval"
+	"github.com/Electronic-Catalog/microkit/metric"
+	"time"
+)
+
+// MetricsMiddleware
+// records each operation with the metric schema of backends (see OutcomeHit), misses are not errors.
+// backend is the first label of metrics, e.g. name of a third-party backend
+func MetricsMiddleware(m metric.Metric, backend string) Middleware {
+	return Intercept(func(ctx context.Context, op Operation, next Invoker) error {
+		start := time.Now()
+		err := next(ctx)
+		recordOperation(m, backend, op.Name, op.Method, start, err)
+
+		return err
+	})
+}
+
+// LoggingMiddleware
+// logs failed operations as errors and the others as debug messages
+func LoggingMiddleware(l logger.Logger) Middleware {
+	return Intercept(func(ctx context.Context, op Operation, next Invoker) error {
+		start := time.Now()
+		err := next(ctx)
+
+		pairs := []keyval.Pair{
+			keyval.String("operation", op.Name),
+			keyval.String("method", op.Method),
+			keyval.String("key", op.Key),
+			keyval.String("duration", time.Since(start).String()),
+		}
+		if err != nil && !isExpectedError(err) {
+			l.Error("cache operation failed", append(pairs, keyval.Error(err))...)
+		} else {
+			l.Debug("cache operation", pairs...)
+		}
+
+		return err
+	})
+}
+
+// StartSpanFunc
+// starts a span of a cache operation and returns the context of span and a function which ends it,
+// it adapts the cache to a tracing library (e.g. OpenTelemetry) without depending on it.
+// end receives nil for misses, they are not failures
+type StartSpanFunc func(ctx context.Context, op Operation) (spanCtx context.Context, end func(err error))
+
+// TracingMiddleware
+// runs each operation in a span which is started by start
+func TracingMiddleware(start StartSpanFunc) Middleware {
+	return Intercept(func(ctx context.Context, op Operation, next Invoker) error {
+		spanCtx, end := start(ctx, op)
+		err := next(spanCtx)
+		if err != nil && !isExpectedError(err) {
+			end(err)
+		} else {
+			end(nil)
+		}
+
+		return err
+	})
+}
+
+// TimeoutMiddleware
+// bounds each operation by timeout, backends which ignore context (e.g. in-memory cache) are not interrupted
+func TimeoutMiddleware(timeout time.Duration) Middleware {
+	return Intercept(func(ctx context.Context, op Operation, next Invoker) error {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		return next(ctx)
+	})
+}
+
+// RetryMiddleware
+// retries failed operations at most retries times, the wait before each retry starts from backoff and doubles.
+// misses, invalid values and canceled calls are not retried, neither are incr and setnx operations
+// because their first attempt may have been applied before it failed
+func RetryMiddleware(retries int, backoff time.Duration) Middleware {
+	return Intercept(func(ctx context.Context, op Operation, next Invoker) error {
+		err := next(ctx)
+		for attempt := 0; attempt < retries && isRetryable(ctx, op, err); attempt++ {
+			timer := time.NewTimer(backoff << attempt)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			case <-timer.C:
+			}
+
+			err = next(ctx)
+		}
+
+		return err
+	})
+}
+
+// isExpectedError reports errors which are normal results of cache operations rather than failures
+func isExpectedError(err error) bool {
+	return errors.Is(err, NotFoundError) || errors.Is(err, KnownMissingError) || errors.Is(err, LockNotAcquiredError)
+}
+
+func isRetryable(ctx context.Context, op Operation, err error) bool {
+	if err == nil || isExpectedError(err) || ctx.Err() != nil {
+		return false
+	}
+
+	if isCallerError(err) {
+		return false
+	}
+
+	switch op.Name {
+	case "incr", "setnx":
+		return false
+	}
+
+	return true
+}
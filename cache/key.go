@@ -0,0 +1,56 @@
+package cache
+
+import (
+	"strconv"
+	"strings"
+)
+
+// KeySeparator
+// separates parts of structured keys, e.g. "user:42:profile"
+const KeySeparator = ":"
+
+var (
+	// the escape character is escaped first, so escaped parts are unescaped without ambiguity
+	keyEscaper   = strings.NewReplacer("%", "%25", KeySeparator, "%3A")
+	keyUnescaper = strings.NewReplacer("%25", "%", "%3A", KeySeparator)
+)
+
+// Key
+// builds a structured key from parts, separators inside parts are escaped,
+// so Key("a:b", "c") and Key("a", "b:c") never collide
+func Key(parts ...string) string {
+	escaped := make([]string, len(parts))
+	for i, part := range parts {
+		escaped[i] = keyEscaper.Replace(part)
+	}
+
+	return strings.Join(escaped, KeySeparator)
+}
+
+// SplitKey
+// returns the parts of a key which is built by Key
+func SplitKey(key string) []string {
+	parts := strings.Split(key, KeySeparator)
+	for i, part := range parts {
+		parts[i] = keyUnescaper.Replace(part)
+	}
+
+	return parts
+}
+
+// keyPrefix
+// is prepended to every key of a namespace, the schema version segment is left out when version is zero
+func keyPrefix(namespace string, version int) string {
+	var parts []string
+	if namespace != "" {
+		parts = append(parts, namespace)
+	}
+	if version > 0 {
+		parts = append(parts, "v"+strconv.Itoa(version))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+
+	return Key(parts...) + KeySeparator
+}
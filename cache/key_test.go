@@ -0,0 +1,128 @@
+package cache
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestKey(t *testing.T) {
+	require.Equal(t, "user:42:profile", Key("user", "42", "profile"))
+	require.NotEqual(t, Key("a:b", "c"), Key("a", "b:c"))
+	require.Equal(t, "a%3Ab:100%25", Key("a:b", "100%"))
+
+	for _, parts := range [][]string{{"user", "42"}, {"a:b", "c"}, {"%3A", "::", ""}} {
+		require.Equal(t, parts, SplitKey(Key(parts...)))
+	}
+
+	require.Equal(t, "", keyPrefix("", 0))
+	require.Equal(t, "catalog:", keyPrefix("catalog", 0))
+	require.Equal(t, "v3:", keyPrefix("", 3))
+	require.Equal(t, "catalog:v3:", keyPrefix("catalog", 3))
+}
+
+func TestRedisKeyPrefix(t *testing.T) {
+	srv := miniredis.RunT(t)
+	ctx := context.Background()
+
+	newCache := func(options ...Option) Cache {
+		c, err := NewRedisCache(append([]Option{WithAddresses(nil, srv.Addr())}, options...)...)
+		require.NoError(t, err)
+		return c
+	}
+
+	catalog := newCache(WithKeyPrefix("catalog"), WithSchemaVersion(1))
+	order := newCache(WithKeyPrefix("order"))
+
+	require.NoError(t, catalog.Set(ctx, "nop", "item:1", "phone", time.Minute))
+	require.NoError(t, order.Set(ctx, "nop", "item:1", "order-1", time.Minute))
+	require.True(t, srv.Exists("catalog:v1:item:1"))
+	require.True(t, srv.Exists("order:item:1"))
+
+	val, err := catalog.GetKey(ctx, "nop", "item:1")
+	require.NoError(t, err)
+	require.Equal(t, "phone", val)
+
+	got, err := MGet(ctx, catalog, "nop", "item:1", "item:2")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"item:1": "phone"}, got)
+
+	// bumping the version hides the previous keyspace
+	catalogV2 := newCache(WithKeyPrefix("catalog"), WithSchemaVersion(2))
+	_, err = catalogV2.GetKey(ctx, "nop", "item:1")
+	require.ErrorIs(t, err, NotFoundError)
+
+	require.NoError(t, catalog.(TaggedCache).SetWithTags(ctx, "nop", "item:2", "laptop", time.Minute, "category:1"))
+	require.True(t, srv.Exists("catalog:v1:tag:category:1"))
+	require.NoError(t, catalogV2.(TaggedCache).InvalidateTags(ctx, "nop", "category:1"))
+	require.True(t, srv.Exists("catalog:v1:item:2"))
+	require.NoError(t, catalog.(TaggedCache).InvalidateTags(ctx, "nop", "category:1"))
+	require.False(t, srv.Exists("catalog:v1:item:2"))
+
+	locker, err := NewLocker(catalog)
+	require.NoError(t, err)
+	_, err = locker.Acquire(ctx, "item:1", time.Minute)
+	require.NoError(t, err)
+	require.True(t, srv.Exists("catalog:v1:lock:item:1"))
+
+	_, err = NewRedisCache(WithAddresses(nil, srv.Addr()), WithSchemaVersion(-1))
+	require.Error(t, err)
+	_, err = NewRedisCache(WithAddresses(nil, srv.Addr()), WithKeyPrefix(""))
+	require.Error(t, err)
+}
+
+func TestRedisClusterKeyPrefix(t *testing.T) {
+	srv := miniredis.RunT(t)
+	ctx := context.Background()
+	cluster, err := NewRedisCache(WithClusterAddresses(srv.Addr()), WithKeyPrefix("catalog"))
+	require.NoError(t, err)
+
+	require.NoError(t, MSet(ctx, cluster, "nop", map[string]string{"item:1": "phone", "item:2": "laptop"}, time.Minute))
+	got, err := MGet(ctx, cluster, "nop", "item:1", "item:2", "item:3")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"item:1": "phone", "item:2": "laptop"}, got)
+
+	require.NoError(t, MDel(ctx, cluster, "nop", "item:1", "item:2"))
+	require.False(t, srv.Exists("catalog:item:1"))
+}
+
+func TestNearCacheKeyPrefix(t *testing.T) {
+	srv := miniredis.RunT(t)
+	ctx := context.Background()
+
+	newNear := func(namespace string) Cache {
+		rd, err := NewRedisCache(WithAddresses(nil, srv.Addr()), WithKeyPrefix(namespace))
+		require.NoError(t, err)
+		nc, err := NewNearCache(rd, WithLocalTTL(time.Minute))
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = nc.Close()
+		})
+		return nc
+	}
+
+	catalogA := newNear("catalog")
+	catalogB := newNear("catalog")
+	order := newNear("order")
+
+	require.NoError(t, catalogA.Set(ctx, "nop", "item:1", "v1", time.Minute))
+	require.NoError(t, order.Set(ctx, "nop", "item:1", "order-1", time.Minute))
+	_, err := catalogB.GetKey(ctx, "nop", "item:1")
+	require.NoError(t, err)
+	_, err = order.GetKey(ctx, "nop", "item:1")
+	require.NoError(t, err)
+
+	// invalidations of another namespace must not drop the local copy of order
+	require.NoError(t, srv.Set("order:item:1", "changed-directly"))
+	require.NoError(t, catalogA.Set(ctx, "nop", "item:1", "v2", time.Minute))
+	require.Eventually(t, func() bool {
+		val, err := catalogB.GetKey(ctx, "nop", "item:1")
+		return err == nil && val == "v2"
+	}, time.Second, time.Millisecond*10)
+
+	val, err := order.GetKey(ctx, "nop", "item:1")
+	require.NoError(t, err)
+	require.Equal(t, "order-1", val)
+}
@@ -0,0 +1,255 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"io"
+	"sync"
+	"time"
+)
+
+// Locker
+// a lock with time to live which is identified by a key, only the owner who acquired the lock
+// (holder of its token) is able to extend or release it, an expired lock is free to be acquired again
+type Locker interface {
+	// Acquire
+	// to take the lock of key for ttl, it doesn't wait for the lock and returns LockNotAcquiredError
+	// when the lock is held by another owner
+	Acquire(ctx context.Context, key string, ttl time.Duration) (token string, err error)
+	// Extend
+	// to reset ttl of a held lock, LockNotHeldError is returned when token doesn't own the lock anymore
+	Extend(ctx context.Context, key string, token string, ttl time.Duration) error
+	// Release
+	// to free the lock, LockNotHeldError is returned when token doesn't own the lock anymore
+	Release(ctx context.Context, key string, token string) error
+}
+
+// validateLockTTL
+// a lock without ttl would never be freed when its owner dies, so ttl has to be positive
+func validateLockTTL(ttl time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("lock ttl must be positive, got %v", ttl)
+	}
+
+	return nil
+}
+
+// lockKey is the redis key which keeps owner token of a lock
+func lockKey(key string) string {
+	return "lock:" + key
+}
+
+var extendScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+type redisLocker struct {
+	client redis.UniversalClient
+	prefix string
+	// owned is set when the client is created for the locker, so Close has to close it
+	owned bool
+}
+
+var _ io.Closer = (*redisLocker)(nil)
+
+// newRedisLocker
+// shares connections and key namespace of rd
+func newRedisLocker(rd *redisCache) *redisLocker {
+	return &redisLocker{client: rd.client, prefix: rd.prefix}
+}
+
+// NewRedisLocker
+// creates a distributed lock on redis, it accepts the same connection and key prefix options as NewRedisCache.
+// the locker owns its connections, it implements io.Closer and the caller has to close it when it is not needed.
+// lockers of NewLocker share the connections of their cache and closing them is a no-op
+func NewRedisLocker(options ...Option) (Locker, error) {
+	c, err := NewRedisCache(options...)
+	if err != nil {
+		return nil, err
+	}
+
+	locker := newRedisLocker(c.(*redisCache))
+	locker.owned = true
+
+	return locker, nil
+}
+
+// NewLocker
+// creates a lock on the backend of cache, a redis cache gives a distributed lock
+// and an in-memory cache a lock which only works in one process, all lockers of an in-memory cache
+// share its lock table, so they exclude each other
+func NewLocker(cache Cache) (Locker, error) {
+	switch c := unwrap(cache).(type) {
+	case *redisCache:
+		return newRedisLocker(c), nil
+	case *memCache:
+		return c.locker, nil
+	case *shardedMemCache:
+		return c.locker, nil
+	default:
+		return nil, fmt.Errorf("locker is not supported by %T", cache)
+	}
+}
+
+func (r *redisLocker) Acquire(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	if err := validateLockTTL(ttl); err != nil {
+		return "", err
+	}
+
+	token := uuid.New().String()
+	acquired, err := r.client.SetNX(ctx, r.prefix+lockKey(key), token, ttl).Result()
+	if err != nil {
+		return "", err
+	}
+	if !acquired {
+		return "", LockNotAcquiredError
+	}
+
+	return token, nil
+}
+
+func (r *redisLocker) Extend(ctx context.Context, key string, token string, ttl time.Duration) error {
+	if err := validateLockTTL(ttl); err != nil {
+		return err
+	}
+
+	extended, err := extendScript.Run(ctx, r.client, []string{r.prefix + lockKey(key)}, token, ttl.Milliseconds()).Int()
+	if err != nil {
+		return err
+	}
+	if extended == 0 {
+		return LockNotHeldError
+	}
+
+	return nil
+}
+
+func (r *redisLocker) Release(ctx context.Context, key string, token string) error {
+	released, err := releaseScript.Run(ctx, r.client, []string{r.prefix + lockKey(key)}, token).Int()
+	if err != nil {
+		return err
+	}
+	if released == 0 {
+		return LockNotHeldError
+	}
+
+	return nil
+}
+
+// Close
+// closes the connections of a locker which is created by NewRedisLocker
+func (r *redisLocker) Close() error {
+	if !r.owned {
+		return nil
+	}
+
+	return r.client.Close()
+}
+
+type memLock struct {
+	token      string
+	expiration time.Time
+}
+
+// minLockPurge is the least number of locks which triggers removing expired ones
+const minLockPurge = 64
+
+type memLocker struct {
+	locks map[string]memLock
+	lock  *sync.Mutex
+	// expired locks are removed when number of locks reaches purgeAt, it is doubled after each purge
+	// so the cost of purging is amortized over acquires
+	purgeAt int
+}
+
+// NewInMemoryLocker
+// creates a lock which only works in one process, it is useful for tests and single replica services
+func NewInMemoryLocker() Locker {
+	return newMemLocker()
+}
+
+func newMemLocker() *memLocker {
+	return &memLocker{
+		locks:   make(map[string]memLock),
+		lock:    &sync.Mutex{},
+		purgeAt: minLockPurge,
+	}
+}
+
+// purge removes expired locks, the caller must hold the lock
+func (m *memLocker) purge(now time.Time) {
+	for key, held := range m.locks {
+		if !now.Before(held.expiration) {
+			delete(m.locks, key)
+		}
+	}
+	m.purgeAt = max(len(m.locks)*2, minLockPurge)
+}
+
+func (m *memLocker) Acquire(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	if err := validateLockTTL(ttl); err != nil {
+		return "", err
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := time.Now()
+	if len(m.locks) >= m.purgeAt {
+		m.purge(now)
+	}
+	if held, ok := m.locks[key]; ok && now.Before(held.expiration) {
+		return "", LockNotAcquiredError
+	}
+
+	token := uuid.New().String()
+	m.locks[key] = memLock{token: token, expiration: now.Add(ttl)}
+
+	return token, nil
+}
+
+func (m *memLocker) Extend(ctx context.Context, key string, token string, ttl time.Duration) error {
+	if err := validateLockTTL(ttl); err != nil {
+		return err
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := time.Now()
+	held, ok := m.locks[key]
+	if !ok || held.token != token || !now.Before(held.expiration) {
+		return LockNotHeldError
+	}
+
+	held.expiration = now.Add(ttl)
+	m.locks[key] = held
+
+	return nil
+}
+
+func (m *memLocker) Release(ctx context.Context, key string, token string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	held, ok := m.locks[key]
+	if !ok || held.token != token || !time.Now().Before(held.expiration) {
+		return LockNotHeldError
+	}
+
+	delete(m.locks, key)
+
+	return nil
+}
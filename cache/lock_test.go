@@ -0,0 +1,94 @@
+package cache
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/require"
+	"io"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestLocker(t *testing.T) {
+	srv := miniredis.RunT(t)
+	redisLocker, err := NewRedisLocker(WithAddresses(nil, srv.Addr()))
+	require.NoError(t, err)
+	defer redisLocker.(io.Closer).Close()
+
+	lockers := map[string]Locker{
+		"redis": redisLocker,
+		"mem":   NewInMemoryLocker(),
+	}
+
+	for name, locker := range lockers {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			token, err := locker.Acquire(ctx, "cron:reindex", time.Millisecond*200)
+			require.NoError(t, err)
+			require.NotEmpty(t, token)
+
+			_, err = locker.Acquire(ctx, "cron:reindex", time.Second)
+			require.ErrorIs(t, err, LockNotAcquiredError)
+
+			// a lock without ttl would never be freed
+			_, err = locker.Acquire(ctx, "cron:other", 0)
+			require.Error(t, err)
+			require.Error(t, locker.Extend(ctx, "cron:reindex", token, -time.Second))
+
+			// only the owner is able to extend or release the lock
+			require.ErrorIs(t, locker.Extend(ctx, "cron:reindex", "someone-else", time.Second), LockNotHeldError)
+			require.ErrorIs(t, locker.Release(ctx, "cron:reindex", "someone-else"), LockNotHeldError)
+			require.NoError(t, locker.Extend(ctx, "cron:reindex", token, time.Second))
+
+			require.NoError(t, locker.Release(ctx, "cron:reindex", token))
+			require.ErrorIs(t, locker.Release(ctx, "cron:reindex", token), LockNotHeldError)
+
+			// an expired lock is free to be taken
+			_, err = locker.Acquire(ctx, "cron:cleanup", time.Millisecond*50)
+			require.NoError(t, err)
+			time.Sleep(time.Millisecond * 60)
+			srv.FastForward(time.Millisecond * 60)
+			_, err = locker.Acquire(ctx, "cron:cleanup", time.Second)
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestNewLockerOfMemCache(t *testing.T) {
+	ctx := context.Background()
+	for name, options := range map[string][]Option{"mem": nil, "sharded": {WithShards(4)}} {
+		t.Run(name, func(t *testing.T) {
+			mem, err := NewInMemoryCache(time.Minute, options...)
+			require.NoError(t, err)
+			defer mem.Close()
+
+			// lockers of one cache exclude each other
+			first, err := NewLocker(mem)
+			require.NoError(t, err)
+			second, err := NewLocker(mem)
+			require.NoError(t, err)
+			_, err = first.Acquire(ctx, "cron:reindex", time.Minute)
+			require.NoError(t, err)
+			_, err = second.Acquire(ctx, "cron:reindex", time.Minute)
+			require.ErrorIs(t, err, LockNotAcquiredError)
+		})
+	}
+}
+
+func TestMemLockerPurge(t *testing.T) {
+	ctx := context.Background()
+	locker := newMemLocker()
+
+	for i := 0; i < minLockPurge; i++ {
+		_, err := locker.Acquire(ctx, "job:"+strconv.Itoa(i), time.Millisecond)
+		require.NoError(t, err)
+	}
+	time.Sleep(time.Millisecond * 5)
+
+	// the next acquire removes the expired locks
+	_, err := locker.Acquire(ctx, "job:new", time.Minute)
+	require.NoError(t, err)
+	require.Len(t, locker.locks, 1)
+}
@@ -3,54 +3,138 @@ package cache
 import (
 	"context"
 	"github.com/Electronic-Catalog/microkit/metric"
+	"math"
+	"strconv"
 	"sync"
 	"time"
 )
 
+// sweepBatch is the maximum number of expired items which are removed in one hold of the lock
+const sweepBatch = 1000
+
 type item struct {
-	value      string // we didn't concern us with process of encoding and decoding value
-	expiration time.Time
+	key        string
+	value      string    // we didn't concern us with process of encoding and decoding value
+	expiration time.Time // zero means the item never expires
+	index      int       // position in expiry heap, -1 when it is not in heap
+}
+
+func (it *item) expired(now time.Time) bool {
+	return !it.expiration.IsZero() && !now.Before(it.expiration)
 }
 
 type memCache struct {
-	store  map[string]item
-	lock   *sync.RWMutex
-	metric metric.Metric
-	timer  *time.Timer
+	store            map[string]*item
+	expiry           expiryHeap
+	lock             *sync.RWMutex
+	metric           metric.Metric
+	evictionInterval time.Duration
+	done             chan struct{}
+	closeOnce        sync.Once
+	// stopped is closed when the background process of removing expired items returns
+	stopped chan struct{}
+
+	// limits, zero means unlimited
+	maxEntries int
+	maxBytes   int64
+	usedBytes  int64
+	policyName EvictionPolicy
+	policy     evictionPolicy // nil when cache is unbounded
+
+	shardCount int
+	snapshot   *snapshotFile
+	// locker is shared by all lockers of NewLocker
+	locker *memLocker
+
+	// tag index, keys of each tag and tags of each key
+	tagKeys map[string]map[string]struct{}
+	keyTags map[string]map[string]struct{}
+}
+
+// record records an operation with the metric schema of backends, err points to the result of operation
+func (m *memCache) record(op string, method string, start time.Time, err *error) {
+	recordOperation(m.metric, "mem", op, method, start, *err)
 }
 
-func (m *memCache) RemoveKey(ctx context.Context, method string, key string) error {
+func (m *memCache) RemoveKey(ctx context.Context, method string, key string) (err error) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 	start := time.Now()
-	defer func(start time.Time) {
-		m.metric.ObserveResponseTime(time.Now().Sub(start), "mem", "del", "method", method)
-	}(start)
+	defer m.record("del", method, start, &err)
 
-	delete(m.store, key)
+	m.deleteItem(key)
 
 	return nil
 }
 
+// NewInMemoryCache
+// expired items are never returned and they are removed from memory in each eviction interval,
+// call Close to stop the background process.
+// by default the cache is unbounded, use WithMaxEntries and WithMaxBytes to limit it
+// and WithEvictionPolicy to choose which keys are evicted when a limit is exceeded.
+// for highly concurrent workloads use WithShards to split the cache into independently locked shards.
+// WithSnapshotFile keeps the cache warm across restarts, it is restored from the file here
 func NewInMemoryCache(evictionInterval time.Duration, options ...Option) (Cache, error) {
-	mm := memCache{
-		store:  make(map[string]item),
-		lock:   &sync.RWMutex{},
-		metric: metric.NewNop(),
-		timer:  time.NewTimer(evictionInterval),
-	}
+	mm := newMemCache(evictionInterval)
 
 	for _, op := range options {
-		err := op(&mm)
+		err := op(mm)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	// background process of removing expired items
-	go mm.evictionProcess()
+	if mm.shardCount > 1 {
+		sc, err := newShardedMemCache(mm)
+		if err != nil {
+			return nil, err
+		}
+		if sc.snapshot != nil {
+			startSnapshots(sc, sc.snapshot, mm.metric, sc.done)
+		}
+		return sc, nil
+	}
+
+	err := mm.start()
+	if err != nil {
+		return nil, err
+	}
+	if mm.snapshot != nil {
+		startSnapshots(mm, mm.snapshot, mm.metric, mm.done)
+	}
+
+	return mm, nil
+}
+
+func newMemCache(evictionInterval time.Duration) *memCache {
+	return &memCache{
+		store:            make(map[string]*item),
+		lock:             &sync.RWMutex{},
+		metric:           metric.NewNop(),
+		evictionInterval: evictionInterval,
+		done:             make(chan struct{}),
+		stopped:          make(chan struct{}),
+		policyName:       LRU,
+		locker:           newMemLocker(),
+		tagKeys:          make(map[string]map[string]struct{}),
+		keyTags:          make(map[string]map[string]struct{}),
+	}
+}
+
+// start
+// creates eviction policy of a bounded cache and runs background process of removing expired items
+func (m *memCache) start() error {
+	if m.maxEntries > 0 || m.maxBytes > 0 {
+		policy, err := newEvictionPolicy(m.policyName, m.maxEntries)
+		if err != nil {
+			return err
+		}
+		m.policy = policy
+	}
+
+	go m.evictionProcess()
 
-	return &mm, nil
+	return nil
 }
 
 func (m *memCache) Ping(ctx context.Context) error {
@@ -58,55 +142,437 @@ func (m *memCache) Ping(ctx context.Context) error {
 	return nil
 }
 
-func (m *memCache) GetKey(ctx context.Context, method string, key string) (string, error) {
-	m.lock.RLock()
-	defer m.lock.RUnlock()
+// Close
+// stops the background process of removing expired items and writes the last snapshot when
+// WithSnapshotFile is given
+func (m *memCache) Close() error {
+	var err error
+	m.closeOnce.Do(func() {
+		close(m.done)
+		if m.snapshot != nil {
+			err = m.snapshot.writeLast(m)
+		}
+	})
+
+	return err
+}
+
+func (m *memCache) GetKey(ctx context.Context, method string, key string) (val string, err error) {
+	unlock := m.lockForRead()
+	defer unlock()
 	start := time.Now()
-	defer func(start time.Time) {
-		m.metric.ObserveResponseTime(time.Now().Sub(start), "mem", "get", "method", method)
-	}(start)
-	if item, ok := m.store[key]; ok {
+	defer m.record("get", method, start, &err)
+	if item, ok := m.store[key]; ok && !item.expired(start) {
+		m.touch(key)
 		return item.value, nil
 	} else {
 		return "", NotFoundError
 	}
 }
 
-func (m *memCache) Set(ctx context.Context, method string, key string, val string, expiration time.Duration) error {
+// Set
+// expiration less than or equal to zero means the key never expires, same as redis
+func (m *memCache) Set(ctx context.Context, method string, key string, val string, expiration time.Duration) (err error) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 	start := time.Now()
-	defer func(start time.Time) {
-		m.metric.ObserveResponseTime(time.Now().Sub(start), "mem", "set", "method", method)
-	}(start)
+	defer m.record("set", method, start, &err)
 
-	m.store[key] = item{
-		value:      val,
-		expiration: time.Now().Add(expiration),
-	}
+	m.setItem(key, val, expirationTime(start, expiration))
+	m.enforceLimits(method)
 
 	return nil
 }
 
 func (m *memCache) evictionProcess() {
-	// this implementation is so ruth and read cache in each interval and if some item expiration
-	// exceeded remove them from mem cache
+	// expired items are kept in a min-heap, so in each interval we only visit the expired ones
+	// and release the lock after each batch to not block readers for a long time
+	ticker := time.NewTicker(m.evictionInterval)
+	defer ticker.Stop()
+	defer close(m.stopped)
 
 	for {
-		<-m.timer.C
-		now := time.Now()
-		m.lock.Lock()
-		// m.lock.RLock() // read lock
-		for key, val := range m.store {
-			if val.expiration.Before(now) {
-				// m.lock.RUnlock() // read unlock
-				// m.lock.Lock() // write lock
-				delete(m.store, key)
-				// m.lock.Unlock() // write unlock
-				// m.lock.RLock() // read lock
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+			for m.sweep(time.Now()) == sweepBatch {
+				// a full batch means there may be more expired items
 			}
 		}
-		// m.lock.RUnlock() // read unlock
-		m.lock.Unlock()
 	}
 }
+
+// sweep
+// removes at most sweepBatch expired items and returns number of removed ones
+func (m *memCache) sweep(now time.Time) int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	removed := 0
+	for ; removed < sweepBatch; removed++ {
+		it, ok := m.expiry.peekExpired(now)
+		if !ok {
+			break
+		}
+		m.deleteItem(it.key)
+	}
+
+	return removed
+}
+
+func (m *memCache) MGet(ctx context.Context, method string, keys ...string) (result map[string]string, err error) {
+	unlock := m.lockForRead()
+	defer unlock()
+	start := time.Now()
+	defer m.record("mget", method, start, &err)
+
+	result = make(map[string]string, len(keys))
+	for _, key := range keys {
+		if item, ok := m.store[key]; ok && !item.expired(start) {
+			m.touch(key)
+			result[key] = item.value
+		}
+	}
+
+	return result, nil
+}
+
+func (m *memCache) MSet(ctx context.Context, method string, items map[string]string, expiration time.Duration) (err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	start := time.Now()
+	defer m.record("mset", method, start, &err)
+
+	exp := expirationTime(start, expiration)
+	for key, val := range items {
+		m.setItem(key, val, exp)
+	}
+	m.enforceLimits(method)
+
+	return nil
+}
+
+func (m *memCache) MDel(ctx context.Context, method string, keys ...string) (err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	start := time.Now()
+	defer m.record("mdel", method, start, &err)
+
+	for _, key := range keys {
+		m.deleteItem(key)
+	}
+
+	return nil
+}
+
+// SetWithTags
+// tags stay attached to key until it is removed or expired
+func (m *memCache) SetWithTags(ctx context.Context, method string, key string, val string, expiration time.Duration, tags ...string) (err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	start := time.Now()
+	defer m.record("set_tags", method, start, &err)
+
+	m.setItem(key, val, expirationTime(start, expiration))
+	for _, tag := range tags {
+		m.tag(key, tag)
+	}
+	m.enforceLimits(method)
+
+	return nil
+}
+
+func (m *memCache) InvalidateTags(ctx context.Context, method string, tags ...string) (err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	start := time.Now()
+	defer m.record("invalidate", method, start, &err)
+
+	for _, tag := range tags {
+		for key := range m.tagKeys[tag] {
+			m.deleteItem(key)
+		}
+	}
+
+	return nil
+}
+
+func (m *memCache) Incr(ctx context.Context, method string, key string) (int64, error) {
+	return m.IncrBy(ctx, method, key, 1)
+}
+
+// IncrBy
+// value is kept as a decimal string, so it is readable by GetKey like redis counters
+func (m *memCache) IncrBy(ctx context.Context, method string, key string, delta int64) (current int64, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	start := time.Now()
+	defer m.record("incr", method, start, &err)
+
+	var expiration time.Time
+	if it, ok := m.store[key]; ok && !it.expired(start) {
+		current, err = strconv.ParseInt(it.value, 10, 64)
+		if err != nil {
+			return 0, NotIntegerError
+		}
+		expiration = it.expiration
+	}
+
+	if (delta > 0 && current > math.MaxInt64-delta) || (delta < 0 && current < math.MinInt64-delta) {
+		return 0, NotIntegerError
+	}
+	current += delta
+
+	m.setItem(key, strconv.FormatInt(current, 10), expiration)
+	m.enforceLimits(method)
+
+	return current, nil
+}
+
+func (m *memCache) Decr(ctx context.Context, method string, key string) (int64, error) {
+	return m.IncrBy(ctx, method, key, -1)
+}
+
+func (m *memCache) SetNX(ctx context.Context, method string, key string, val string, expiration time.Duration) (stored bool, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	start := time.Now()
+	defer m.record("setnx", method, start, &err)
+
+	if it, ok := m.store[key]; ok && !it.expired(start) {
+		return false, nil
+	}
+
+	m.setItem(key, val, expirationTime(start, expiration))
+	m.enforceLimits(method)
+
+	return true, nil
+}
+
+func (m *memCache) TTL(ctx context.Context, method string, key string) (ttl time.Duration, err error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	start := time.Now()
+	defer m.record("ttl", method, start, &err)
+
+	it, ok := m.store[key]
+	if !ok || it.expired(start) {
+		return 0, NotFoundError
+	}
+	if it.expiration.IsZero() {
+		return NoExpiration, nil
+	}
+
+	return it.expiration.Sub(start), nil
+}
+
+func (m *memCache) Expire(ctx context.Context, method string, key string, expiration time.Duration) (err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	start := time.Now()
+	defer m.record("expire", method, start, &err)
+
+	it, ok := m.store[key]
+	if !ok || it.expired(start) {
+		return NotFoundError
+	}
+
+	it.expiration = expirationTime(start, expiration)
+	m.expiry.track(it)
+
+	return nil
+}
+
+// tag attaches tag to key, the caller must hold the write lock
+func (m *memCache) tag(key string, tag string) {
+	if m.tagKeys[tag] == nil {
+		m.tagKeys[tag] = make(map[string]struct{})
+	}
+	m.tagKeys[tag][key] = struct{}{}
+
+	if m.keyTags[key] == nil {
+		m.keyTags[key] = make(map[string]struct{})
+	}
+	m.keyTags[key][tag] = struct{}{}
+}
+
+// untag detaches all tags of key, the caller must hold the write lock
+func (m *memCache) untag(key string) {
+	for tag := range m.keyTags[key] {
+		delete(m.tagKeys[tag], key)
+		if len(m.tagKeys[tag]) == 0 {
+			delete(m.tagKeys, tag)
+		}
+	}
+	delete(m.keyTags, key)
+}
+
+// flush
+// removes all stored items
+func (m *memCache) flush() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for key := range m.store {
+		m.deleteItem(key)
+	}
+}
+
+// lockForRead
+// bounded caches track usage of keys on read, so they need the write lock
+func (m *memCache) lockForRead() func() {
+	if m.policy != nil {
+		m.lock.Lock()
+		return m.lock.Unlock
+	}
+
+	m.lock.RLock()
+	return m.lock.RUnlock
+}
+
+// setItem stores value of key and keeps track of expiration and memory usage,
+// the caller must hold the write lock
+func (m *memCache) setItem(key string, val string, expiration time.Time) {
+	it, ok := m.store[key]
+	if ok {
+		m.usedBytes -= itemSize(it)
+		if m.policy != nil {
+			m.policy.touch(key)
+		}
+	} else {
+		it = &item{key: key, index: -1}
+		m.store[key] = it
+		if m.policy != nil {
+			m.policy.add(key)
+		}
+	}
+
+	it.value = val
+	it.expiration = expiration
+	m.expiry.track(it)
+	m.usedBytes += itemSize(it)
+}
+
+// deleteItem removes key and keeps track of expiration and memory usage,
+// the caller must hold the write lock
+func (m *memCache) deleteItem(key string) {
+	it, ok := m.store[key]
+	if !ok {
+		return
+	}
+
+	delete(m.store, key)
+	m.untag(key)
+	m.expiry.untrack(it)
+	m.usedBytes -= itemSize(it)
+	if m.policy != nil {
+		m.policy.remove(key)
+	}
+}
+
+func (m *memCache) touch(key string) {
+	if m.policy != nil {
+		m.policy.touch(key)
+	}
+}
+
+// enforceLimits
+// evicts keys chosen by eviction policy until cache is in its limits, evictions are recorded as evict op
+// of method whose write exceeded the limits, the caller must hold the write lock
+func (m *memCache) enforceLimits(method string) {
+	if m.policy == nil {
+		return
+	}
+
+	for (m.maxEntries > 0 && len(m.store) > m.maxEntries) || (m.maxBytes > 0 && m.usedBytes > m.maxBytes) {
+		key, ok := m.policy.victim()
+		if !ok {
+			return
+		}
+
+		m.deleteItem(key)
+		m.metric.IncrementTotal("mem", "evict", method, OutcomeEvicted)
+	}
+}
+
+// itemSize is an estimation of memory used by an item, map and policy overhead is not included
+func itemSize(it *item) int64 {
+	return int64(len(it.key) + len(it.value))
+}
+
+// expirationTime converts a relative expiration to an absolute one, zero time means no expiration
+func expirationTime(now time.Time, expiration time.Duration) time.Time {
+	if expiration <= 0 {
+		return time.Time{}
+	}
+
+	return now.Add(expiration)
+}
+
+// Scan
+// walks the store under the read lock and collects matching keys, so the iterator doesn't hold the lock,
+// count is ignored because all keys are collected at once
+func (m *memCache) Scan(ctx context.Context, method string, pattern string, count int64) KeyIterator {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	start := time.Now()
+	defer recordOperation(m.metric, "mem", "scan", method, start, nil)
+
+	return &sliceIterator{keys: m.match(pattern, start)}
+}
+
+// DeleteByPattern
+// takes the write lock once per batch, so readers are not blocked until all keys are removed
+func (m *memCache) DeleteByPattern(ctx context.Context, method string, pattern string, batchSize int) (removed int64, err error) {
+	start := time.Now()
+	defer m.record("delete_pattern", method, start, &err)
+
+	if batchSize <= 0 {
+		batchSize = defaultDeleteBatch
+	}
+
+	m.lock.RLock()
+	keys := m.match(pattern, start)
+	m.lock.RUnlock()
+
+	for len(keys) > 0 {
+		if err := ctx.Err(); err != nil {
+			return removed, err
+		}
+
+		n := min(batchSize, len(keys))
+		removed += m.deleteKeys(keys[:n])
+		keys = keys[n:]
+	}
+
+	return removed, nil
+}
+
+// match returns keys which match pattern and are not expired, the caller must hold the read lock
+func (m *memCache) match(pattern string, now time.Time) []string {
+	var keys []string
+	for key, it := range m.store {
+		if !it.expired(now) && matchPattern(pattern, key) {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// deleteKeys removes keys which still exist and returns number of removed ones
+func (m *memCache) deleteKeys(keys []string) int64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var removed int64
+	for _, key := range keys {
+		if _, ok := m.store[key]; ok {
+			m.deleteItem(key)
+			removed++
+		}
+	}
+
+	return removed
+}
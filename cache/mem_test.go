@@ -2,7 +2,9 @@ package cache
 
 import (
 	"context"
+	"fmt"
 	"github.com/Electronic-Catalog/microkit/metric"
+	"github.com/Electronic-Catalog/microkit/metric/metrictest"
 	"github.com/stretchr/testify/require"
 	"testing"
 	"time"
@@ -30,3 +32,166 @@ func TestMemCache(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, "test1", val)
 }
+
+func TestMemCacheEviction(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("lru", func(t *testing.T) {
+		mt := metrictest.NewRecorder()
+		mem, err := NewInMemoryCache(time.Minute, WithMaxEntries(3), WithMetricOption(mt))
+		require.NoError(t, err)
+
+		for _, key := range []string{"a", "b", "c"} {
+			require.NoError(t, mem.Set(ctx, "nop", key, key, time.Minute))
+		}
+		_, err = mem.GetKey(ctx, "nop", "a")
+		require.NoError(t, err)
+		require.NoError(t, mem.Set(ctx, "nop", "d", "d", time.Minute))
+
+		_, err = mem.GetKey(ctx, "nop", "b")
+		require.ErrorIs(t, err, NotFoundError)
+		for _, key := range []string{"a", "c", "d"} {
+			_, err = mem.GetKey(ctx, "nop", key)
+			require.NoError(t, err)
+		}
+		require.Equal(t, 1, mt.Total("mem", "evict", "nop", OutcomeEvicted))
+	})
+
+	t.Run("lfu", func(t *testing.T) {
+		mem, err := NewInMemoryCache(time.Minute, WithMaxEntries(2), WithEvictionPolicy(LFU))
+		require.NoError(t, err)
+
+		require.NoError(t, mem.Set(ctx, "nop", "a", "a", time.Minute))
+		require.NoError(t, mem.Set(ctx, "nop", "b", "b", time.Minute))
+		for i := 0; i < 3; i++ {
+			_, err = mem.GetKey(ctx, "nop", "a")
+			require.NoError(t, err)
+		}
+		require.NoError(t, mem.Set(ctx, "nop", "c", "c", time.Minute))
+
+		_, err = mem.GetKey(ctx, "nop", "b")
+		require.ErrorIs(t, err, NotFoundError)
+		_, err = mem.GetKey(ctx, "nop", "a")
+		require.NoError(t, err)
+	})
+
+	t.Run("w-tinylfu resists scans", func(t *testing.T) {
+		mem, err := NewInMemoryCache(time.Minute, WithMaxEntries(100), WithEvictionPolicy(WTinyLFU))
+		require.NoError(t, err)
+
+		for i := 0; i < 100; i++ {
+			require.NoError(t, mem.Set(ctx, "nop", fmt.Sprintf("hot%d", i), "v", time.Minute))
+		}
+		for round := 0; round < 5; round++ {
+			for i := 0; i < 100; i++ {
+				_, _ = mem.GetKey(ctx, "nop", fmt.Sprintf("hot%d", i))
+			}
+		}
+		// a catalog-wide scan of keys which are used once
+		for i := 0; i < 1000; i++ {
+			require.NoError(t, mem.Set(ctx, "nop", fmt.Sprintf("scan%d", i), "v", time.Minute))
+		}
+
+		hits := 0
+		for i := 0; i < 100; i++ {
+			if _, err := mem.GetKey(ctx, "nop", fmt.Sprintf("hot%d", i)); err == nil {
+				hits++
+			}
+		}
+		require.Greater(t, hits, 90)
+		require.LessOrEqual(t, len(mem.(*memCache).store), 100)
+	})
+
+	t.Run("max bytes", func(t *testing.T) {
+		mem, err := NewInMemoryCache(time.Minute, WithMaxBytes(30))
+		require.NoError(t, err)
+
+		for i := 0; i < 10; i++ {
+			// 2 bytes of key and 8 bytes of value
+			require.NoError(t, mem.Set(ctx, "nop", fmt.Sprintf("k%d", i), "12345678", time.Minute))
+		}
+		mc := mem.(*memCache)
+		require.Len(t, mc.store, 3)
+		require.Equal(t, int64(30), mc.usedBytes)
+	})
+
+	t.Run("unknown policy", func(t *testing.T) {
+		_, err := NewInMemoryCache(time.Minute, WithEvictionPolicy("fifo"))
+		require.Error(t, err)
+	})
+}
+
+func TestMemCacheExpiry(t *testing.T) {
+	ctx := context.Background()
+	mem, err := NewInMemoryCache(time.Millisecond * 20)
+	require.NoError(t, err)
+	defer mem.Close()
+	mc := mem.(*memCache)
+
+	// expired items are purged in every interval, not only the first one
+	for round := 0; round < 3; round++ {
+		require.NoError(t, mem.Set(ctx, "nop", "short", "v", time.Millisecond*10))
+		require.Eventually(t, func() bool {
+			mc.lock.RLock()
+			defer mc.lock.RUnlock()
+			return len(mc.store) == 0 && mc.expiry.Len() == 0
+		}, time.Second, time.Millisecond*5)
+	}
+
+	// zero expiration never expires
+	require.NoError(t, mem.Set(ctx, "nop", "forever", "v", 0))
+	time.Sleep(time.Millisecond * 50)
+	val, err := mem.GetKey(ctx, "nop", "forever")
+	require.NoError(t, err)
+	require.Equal(t, "v", val)
+
+	// re-setting a key moves its expiration
+	require.NoError(t, mem.Set(ctx, "nop", "moved", "v", time.Millisecond*10))
+	require.NoError(t, mem.Set(ctx, "nop", "moved", "v", time.Minute))
+	time.Sleep(time.Millisecond * 50)
+	_, err = mem.GetKey(ctx, "nop", "moved")
+	require.NoError(t, err)
+}
+
+func TestMemCacheExpiredOnRead(t *testing.T) {
+	ctx := context.Background()
+	// the sweeper doesn't run during the test
+	mem, err := NewInMemoryCache(time.Hour)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set(ctx, "nop", "key", "v", time.Millisecond))
+	time.Sleep(time.Millisecond * 5)
+
+	_, err = mem.GetKey(ctx, "nop", "key")
+	require.ErrorIs(t, err, NotFoundError)
+
+	got, err := MGet(ctx, mem, "nop", "key")
+	require.NoError(t, err)
+	require.Empty(t, got)
+}
+
+func TestMemCacheClose(t *testing.T) {
+	mem, err := NewInMemoryCache(time.Millisecond)
+	require.NoError(t, err)
+	require.NoError(t, mem.Close())
+	require.NoError(t, mem.Close())
+
+	// the background process which is started by NewInMemoryCache returns once cache is closed
+	select {
+	case <-mem.(*memCache).stopped:
+	case <-time.After(time.Second):
+		t.Fatal("eviction process is still running after Close")
+	}
+
+	sharded, err := NewInMemoryCache(time.Millisecond, WithShards(4))
+	require.NoError(t, err)
+	require.NoError(t, sharded.Close())
+	for _, shard := range sharded.(*shardedMemCache).shards {
+		select {
+		case <-shard.stopped:
+		case <-time.After(time.Second):
+			t.Fatal("eviction process of a shard is still running after Close")
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"github.com/Electronic-Catalog/microkit/metric"
+	"github.com/go-redis/redis/v8"
+	"io"
+	"net"
+	"time"
+)
+
+// backends and MetricsMiddleware record operations with one schema, so the same dashboards work for all of them:
+//
+//	total and response time: backend, op, method, outcome
+//	error:                   backend, op, method, kind
+//
+// op is the name of the operation (get, set, del, mget, mset, mdel, incr, setnx, ttl, expire, set_tags,
+// invalidate, scan, delete_pattern, and evict of in-memory caches) and method is the method which is given
+// by the caller. wrappers record their own ops with the same schema, e.g. fallback of a circuit breaker,
+// seal and open of a secure cache or load of ReadThrough, so one metric instance can be shared by all of them, e.g.
+//
+//	m := metric.RegisterMetric("app", "cache", "operation",
+//		metric.Labels("backend", "op", "method", "outcome"),
+//		metric.ErrorLabels("backend", "op", "method", "kind"))
+const (
+	// OutcomeHit means the operation is done, writes which succeed are hits as well
+	OutcomeHit = "hit"
+	// OutcomeMiss means the key is not found, misses are not errors
+	OutcomeMiss = "miss"
+	// OutcomeKnownMissing means the key is cached as missing by a negative cache, it is not an error either
+	OutcomeKnownMissing = "known_missing"
+	// OutcomeError means the operation is failed, kind of the error is recorded in error metric
+	OutcomeError = "error"
+	// OutcomeTimeout means the operation is not done before its deadline
+	OutcomeTimeout = "timeout"
+	// OutcomeEvicted means a key is dropped by the eviction policy of an in-memory cache, it is recorded by evict op
+	OutcomeEvicted = "evicted"
+	// OutcomeTransition means a circuit breaker moved to the state of its op (open, half_open or close)
+	OutcomeTransition = "transition"
+)
+
+// recordOperation
+// records an operation of backend which is started at start and returned err
+func recordOperation(m metric.Metric, backend string, op string, method string, start time.Time, err error) {
+	outcome := operationOutcome(err)
+	m.IncrementTotal(backend, op, method, outcome)
+	m.ObserveResponseTime(time.Since(start), backend, op, method, outcome)
+	if outcome == OutcomeError || outcome == OutcomeTimeout {
+		m.IncrementError(backend, op, method, errorKind(err))
+	}
+}
+
+func operationOutcome(err error) string {
+	switch {
+	case err == nil:
+		return OutcomeHit
+	case errors.Is(err, KnownMissingError):
+		return OutcomeKnownMissing
+	case isExpectedError(err):
+		return OutcomeMiss
+	case errorKind(err) == "timeout":
+		return OutcomeTimeout
+	default:
+		return OutcomeError
+	}
+}
+
+// errorKind
+// classifies errors for metric labels as timeout, canceled, not_integer, decode, server (error replies of redis),
+// connection or error, messages of errors are not used because their cardinality is unbounded
+func errorKind(err error) string {
+	var netErr net.Error
+	var decodeErr *DecodeError
+	var redisErr redis.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, NotIntegerError):
+		return "not_integer"
+	case errors.As(err, &decodeErr):
+		return "decode"
+	case errors.As(err, &redisErr):
+		return "server"
+	case errors.As(err, &netErr), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, redis.ErrClosed):
+		return "connection"
+	default:
+		return "error"
+	}
+}
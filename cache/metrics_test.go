@@ -0,0 +1,102 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Electronic-Catalog/microkit/metric/metrictest"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestOperationOutcome(t *testing.T) {
+	tests := []struct {
+		err     error
+		outcome string
+		kind    string
+	}{
+		{nil, OutcomeHit, ""},
+		{NotFoundError, OutcomeMiss, ""},
+		{fmt.Errorf("product: %w", KnownMissingError), OutcomeKnownMissing, ""},
+		{context.DeadlineExceeded, OutcomeTimeout, "timeout"},
+		{&net.OpError{Op: "read", Err: timeoutError{}}, OutcomeTimeout, "timeout"},
+		{context.Canceled, OutcomeError, "canceled"},
+		{NotIntegerError, OutcomeError, "not_integer"},
+		{&DecodeError{Err: errors.New("invalid")}, OutcomeError, "decode"},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, OutcomeError, "connection"},
+		{io.EOF, OutcomeError, "connection"},
+		{redis.ErrClosed, OutcomeError, "connection"},
+		{errors.New("unknown"), OutcomeError, "error"},
+	}
+
+	for _, test := range tests {
+		require.Equal(t, test.outcome, operationOutcome(test.err), test.err)
+		if test.kind != "" {
+			require.Equal(t, test.kind, errorKind(test.err), test.err)
+		}
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string {
+	return "i/o timeout"
+}
+
+func (timeoutError) Timeout() bool {
+	return true
+}
+
+func (timeoutError) Temporary() bool {
+	return true
+}
+
+func TestBackendMetrics(t *testing.T) {
+	srv := miniredis.RunT(t)
+	redisMetric := metrictest.NewRecorder()
+	rd, err := NewRedisCache(WithAddresses(nil, srv.Addr()), WithMetricOption(redisMetric))
+	require.NoError(t, err)
+	memMetric := metrictest.NewRecorder()
+	mem, err := NewInMemoryCache(time.Minute, WithMetricOption(memMetric))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	backends := map[string]struct {
+		cache  Cache
+		metric *metrictest.Recorder
+	}{
+		"redis": {rd, redisMetric},
+		"mem":   {mem, memMetric},
+	}
+
+	for backend, b := range backends {
+		t.Run(backend, func(t *testing.T) {
+			ctx := context.Background()
+			require.NoError(t, b.cache.Set(ctx, "product", "p1", "v1", time.Minute))
+			_, err := b.cache.GetKey(ctx, "product", "p1")
+			require.NoError(t, err)
+			_, err = b.cache.GetKey(ctx, "product", "p2")
+			require.ErrorIs(t, err, NotFoundError)
+			_, err = b.cache.Incr(ctx, "counter", "p1")
+			require.ErrorIs(t, err, NotIntegerError)
+
+			require.Equal(t, 1, b.metric.Total(backend, "set", "product", "hit"))
+			require.Equal(t, 1, b.metric.Total(backend, "get", "product", "hit"))
+			require.Equal(t, 1, b.metric.Total(backend, "get", "product", "miss"))
+			require.Equal(t, 1, b.metric.Total(backend, "incr", "counter", "error"))
+			require.Equal(t, 1, b.metric.Error(backend, "incr", "counter", "not_integer"))
+			// misses are not errors
+			require.Equal(t, 0, b.metric.Error(backend, "get", "product", "error"))
+		})
+	}
+
+	srv.SetError("server is down")
+	_, err = rd.GetKey(context.Background(), "product", "p1")
+	require.Error(t, err)
+	require.Equal(t, 1, redisMetric.Error("redis", "get", "product", "server"))
+}
@@ -0,0 +1,245 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// Middleware
+// decorates a cache with a cross-cutting concern (e.g. metrics, logging or retries),
+// it works on every Cache implementation including the ones outside of this package
+type Middleware func(Cache) Cache
+
+// Operation
+// describes a cache call which passes through an Interceptor
+type Operation struct {
+	// Name of the cache operation: ping, get, set, del, incr, setnx, ttl, expire, mget, mset, mdel,
+	// set_tags or invalidate
+	Name string
+	// Method is the method argument of the call, it is empty for ping
+	Method string
+	// Key of single key operations, it is empty for batch and tag operations
+	Key string
+}
+
+// Invoker
+// runs the rest of the chain for an operation, it may be called several times (e.g. by retries)
+type Invoker func(ctx context.Context) error
+
+// Interceptor
+// runs around every operation of a cache, it has to call next to run the operation
+type Interceptor func(ctx context.Context, op Operation, next Invoker) error
+
+// Intercept
+// turns an interceptor into a middleware, Close is not intercepted.
+// batch operations are forwarded to the batch operations of the wrapped cache when it has them,
+// and tag operations are only available when the wrapped cache implements TaggedCache
+func Intercept(interceptor Interceptor) Middleware {
+	return func(next Cache) Cache {
+		ic := &interceptedCache{next: next, interceptor: interceptor}
+		if tc, ok := next.(TaggedCache); ok {
+			return &interceptedTaggedCache{interceptedCache: ic, next: tc}
+		}
+
+		return ic
+	}
+}
+
+// Chain
+// applies middlewares to cache, the first middleware is the outermost one and sees every call first
+func Chain(cache Cache, middlewares ...Middleware) Cache {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		cache = middlewares[i](cache)
+	}
+
+	return cache
+}
+
+// Builder
+// composes middlewares of a cache, e.g.
+//
+//	c := cache.NewBuilder(rd).
+//		Use(cache.MetricsMiddleware(m, "redis"), cache.LoggingMiddleware(l)).
+//		Use(cache.RetryMiddleware(2, time.Millisecond*50), cache.TimeoutMiddleware(time.Millisecond*200)).
+//		Build()
+//
+// middlewares which are added first are the outer ones, so in the example above each retry has its own timeout
+// and metrics record the whole call with its retries
+type Builder struct {
+	cache       Cache
+	middlewares []Middleware
+}
+
+func NewBuilder(cache Cache) *Builder {
+	return &Builder{cache: cache}
+}
+
+// Use
+// appends middlewares to the chain
+func (b *Builder) Use(middlewares ...Middleware) *Builder {
+	b.middlewares = append(b.middlewares, middlewares...)
+	return b
+}
+
+// Build
+// returns the cache decorated by all middlewares
+func (b *Builder) Build() Cache {
+	return Chain(b.cache, b.middlewares...)
+}
+
+type interceptedCache struct {
+	next        Cache
+	interceptor Interceptor
+}
+
+func (c *interceptedCache) Ping(ctx context.Context) error {
+	return c.interceptor(ctx, Operation{Name: "ping"}, func(ctx context.Context) error {
+		return c.next.Ping(ctx)
+	})
+}
+
+func (c *interceptedCache) GetKey(ctx context.Context, method string, key string) (string, error) {
+	var val string
+	err := c.interceptor(ctx, Operation{Name: "get", Method: method, Key: key}, func(ctx context.Context) error {
+		var err error
+		val, err = c.next.GetKey(ctx, method, key)
+		return err
+	})
+
+	return val, err
+}
+
+func (c *interceptedCache) Set(ctx context.Context, method string, key string, val string, expiration time.Duration) error {
+	return c.interceptor(ctx, Operation{Name: "set", Method: method, Key: key}, func(ctx context.Context) error {
+		return c.next.Set(ctx, method, key, val, expiration)
+	})
+}
+
+func (c *interceptedCache) RemoveKey(ctx context.Context, method string, key string) error {
+	return c.interceptor(ctx, Operation{Name: "del", Method: method, Key: key}, func(ctx context.Context) error {
+		return c.next.RemoveKey(ctx, method, key)
+	})
+}
+
+func (c *interceptedCache) Incr(ctx context.Context, method string, key string) (int64, error) {
+	var val int64
+	err := c.interceptor(ctx, Operation{Name: "incr", Method: method, Key: key}, func(ctx context.Context) error {
+		var err error
+		val, err = c.next.Incr(ctx, method, key)
+		return err
+	})
+
+	return val, err
+}
+
+func (c *interceptedCache) IncrBy(ctx context.Context, method string, key string, delta int64) (int64, error) {
+	var val int64
+	err := c.interceptor(ctx, Operation{Name: "incr", Method: method, Key: key}, func(ctx context.Context) error {
+		var err error
+		val, err = c.next.IncrBy(ctx, method, key, delta)
+		return err
+	})
+
+	return val, err
+}
+
+func (c *interceptedCache) Decr(ctx context.Context, method string, key string) (int64, error) {
+	var val int64
+	err := c.interceptor(ctx, Operation{Name: "incr", Method: method, Key: key}, func(ctx context.Context) error {
+		var err error
+		val, err = c.next.Decr(ctx, method, key)
+		return err
+	})
+
+	return val, err
+}
+
+func (c *interceptedCache) SetNX(ctx context.Context, method string, key string, val string, expiration time.Duration) (bool, error) {
+	var stored bool
+	err := c.interceptor(ctx, Operation{Name: "setnx", Method: method, Key: key}, func(ctx context.Context) error {
+		var err error
+		stored, err = c.next.SetNX(ctx, method, key, val, expiration)
+		return err
+	})
+
+	return stored, err
+}
+
+func (c *interceptedCache) TTL(ctx context.Context, method string, key string) (time.Duration, error) {
+	var ttl time.Duration
+	err := c.interceptor(ctx, Operation{Name: "ttl", Method: method, Key: key}, func(ctx context.Context) error {
+		var err error
+		ttl, err = c.next.TTL(ctx, method, key)
+		return err
+	})
+
+	return ttl, err
+}
+
+func (c *interceptedCache) Expire(ctx context.Context, method string, key string, expiration time.Duration) error {
+	return c.interceptor(ctx, Operation{Name: "expire", Method: method, Key: key}, func(ctx context.Context) error {
+		return c.next.Expire(ctx, method, key, expiration)
+	})
+}
+
+func (c *interceptedCache) MGet(ctx context.Context, method string, keys ...string) (map[string]string, error) {
+	var vals map[string]string
+	err := c.interceptor(ctx, Operation{Name: "mget", Method: method}, func(ctx context.Context) error {
+		var err error
+		vals, err = MGet(ctx, c.next, method, keys...)
+		return err
+	})
+
+	return vals, err
+}
+
+func (c *interceptedCache) MSet(ctx context.Context, method string, items map[string]string, expiration time.Duration) error {
+	return c.interceptor(ctx, Operation{Name: "mset", Method: method}, func(ctx context.Context) error {
+		return MSet(ctx, c.next, method, items, expiration)
+	})
+}
+
+func (c *interceptedCache) MDel(ctx context.Context, method string, keys ...string) error {
+	return c.interceptor(ctx, Operation{Name: "mdel", Method: method}, func(ctx context.Context) error {
+		return MDel(ctx, c.next, method, keys...)
+	})
+}
+
+func (c *interceptedCache) Close() error {
+	return c.next.Close()
+}
+
+// Unwrap
+// returns the decorated cache, so helpers which need a specific backend (e.g. RedisClient) see through middlewares
+func (c *interceptedCache) Unwrap() Cache {
+	return c.next
+}
+
+// unwrap returns the innermost cache of middlewares
+func unwrap(cache Cache) Cache {
+	for {
+		w, ok := cache.(interface{ Unwrap() Cache })
+		if !ok {
+			return cache
+		}
+		cache = w.Unwrap()
+	}
+}
+
+// interceptedTaggedCache keeps tag operations of the wrapped cache available
+type interceptedTaggedCache struct {
+	*interceptedCache
+	next TaggedCache
+}
+
+func (c *interceptedTaggedCache) SetWithTags(ctx context.Context, method string, key string, val string, expiration time.Duration, tags ...string) error {
+	return c.interceptor(ctx, Operation{Name: "set_tags", Method: method, Key: key}, func(ctx context.Context) error {
+		return c.next.SetWithTags(ctx, method, key, val, expiration, tags...)
+	})
+}
+
+func (c *interceptedTaggedCache) InvalidateTags(ctx context.Context, method string, tags ...string) error {
+	return c.interceptor(ctx, Operation{Name: "invalidate", Method: method}, func(ctx context.Context) error {
+		return c.next.InvalidateTags(ctx, method, tags...)
+	})
+}
@@ -0,0 +1,231 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/Electronic-Catalog/microkit/logger/keyval"
+	"github.com/Electronic-Catalog/microkit/metric/metrictest"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyCache fails the first failures calls of GetKey and Incr with err
+type flakyCache struct {
+	Cache
+	failures atomic.Int32
+	calls    atomic.Int32
+	err      error
+}
+
+func (f *flakyCache) GetKey(ctx context.Context, method string, key string) (string, error) {
+	f.calls.Add(1)
+	if f.failures.Add(-1) >= 0 {
+		return "", f.err
+	}
+	return f.Cache.GetKey(ctx, method, key)
+}
+
+func (f *flakyCache) Incr(ctx context.Context, method string, key string) (int64, error) {
+	f.calls.Add(1)
+	if f.failures.Add(-1) >= 0 {
+		return 0, f.err
+	}
+	return f.Cache.Incr(ctx, method, key)
+}
+
+// slowCache blocks GetKey until its context is done
+type slowCache struct {
+	Cache
+}
+
+func (s slowCache) GetKey(ctx context.Context, method string, key string) (string, error) {
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+// recordingLogger keeps messages of each level
+type recordingLogger struct {
+	lock     sync.Mutex
+	messages map[string][]string
+}
+
+func (r *recordingLogger) record(level string, message string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.messages[level] = append(r.messages[level], message)
+}
+
+func (r *recordingLogger) Debug(message string, keyAndValues ...keyval.Pair) {
+	r.record("debug", message)
+}
+
+func (r *recordingLogger) Info(message string, keyAndValues ...keyval.Pair) {
+	r.record("info", message)
+}
+
+func (r *recordingLogger) Warn(message string, keyAndValues ...keyval.Pair) {
+	r.record("warn", message)
+}
+
+func (r *recordingLogger) Error(message string, keyAndValues ...keyval.Pair) {
+	r.record("error", message)
+}
+
+func (r *recordingLogger) Panic(message string, keyAndValues ...keyval.Pair) {
+	r.record("panic", message)
+}
+
+func newMemForTest(t *testing.T) Cache {
+	mem, err := NewInMemoryCache(time.Minute)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = mem.Close()
+	})
+	return mem
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	var calls []string
+	record := func(name string) Middleware {
+		return Intercept(func(ctx context.Context, op Operation, next Invoker) error {
+			calls = append(calls, name+">"+op.Name)
+			err := next(ctx)
+			calls = append(calls, name+"<"+op.Name)
+			return err
+		})
+	}
+
+	c := NewBuilder(newMemForTest(t)).Use(record("outer")).Use(record("inner")).Build()
+	require.NoError(t, c.Set(context.Background(), "nop", "a", "1", time.Minute))
+	require.Equal(t, []string{"outer>set", "inner>set", "inner<set", "outer<set"}, calls)
+
+	// tag operations are only kept for tagged backends
+	_, ok := c.(TaggedCache)
+	require.True(t, ok)
+	_, ok = Chain(plainCache{newMemForTest(t)}, record("outer")).(TaggedCache)
+	require.False(t, ok)
+	require.NoError(t, MSet(context.Background(), c, "nop", map[string]string{"b": "2"}, time.Minute))
+	require.Equal(t, "outer>mset", calls[4])
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	ctx := context.Background()
+	mt := metrictest.NewRecorder()
+	flaky := &flakyCache{Cache: newMemForTest(t), err: errors.New("connection reset")}
+	flaky.failures.Store(1)
+	c := Chain(flaky, MetricsMiddleware(mt, "custom"))
+
+	_, err := c.GetKey(ctx, "product", "p1")
+	require.Error(t, err)
+	_, err = c.GetKey(ctx, "product", "p1")
+	require.ErrorIs(t, err, NotFoundError)
+
+	require.Equal(t, 1, mt.Total("custom", "get", "product", "error"))
+	require.Equal(t, 1, mt.Total("custom", "get", "product", "miss"))
+	require.Equal(t, 1, mt.Error("custom", "get", "product", "error"))
+
+	timed := Chain(slowCache{newMemForTest(t)}, MetricsMiddleware(mt, "custom"), TimeoutMiddleware(time.Millisecond*20))
+	_, err = timed.GetKey(ctx, "product", "p1")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Equal(t, 1, mt.Total("custom", "get", "product", "timeout"))
+	require.Equal(t, 1, mt.Error("custom", "get", "product", "timeout"))
+}
+
+func TestRetryMiddleware(t *testing.T) {
+	ctx := context.Background()
+	mem := newMemForTest(t)
+	require.NoError(t, mem.Set(ctx, "nop", "p1", "v1", time.Minute))
+
+	flaky := &flakyCache{Cache: mem, err: errors.New("connection reset")}
+	flaky.failures.Store(2)
+	c := Chain(flaky, RetryMiddleware(2, time.Millisecond))
+	val, err := c.GetKey(ctx, "nop", "p1")
+	require.NoError(t, err)
+	require.Equal(t, "v1", val)
+	require.Equal(t, int32(3), flaky.calls.Load())
+
+	// misses are not retried
+	flaky.calls.Store(0)
+	_, err = c.GetKey(ctx, "nop", "missing")
+	require.ErrorIs(t, err, NotFoundError)
+	require.Equal(t, int32(1), flaky.calls.Load())
+
+	// incr may have been applied before it failed
+	flaky.calls.Store(0)
+	flaky.failures.Store(1)
+	_, err = c.Incr(ctx, "nop", "views")
+	require.Error(t, err)
+	require.Equal(t, int32(1), flaky.calls.Load())
+
+	// retries give up after the last one
+	flaky.calls.Store(0)
+	flaky.failures.Store(5)
+	_, err = c.GetKey(ctx, "nop", "p1")
+	require.Error(t, err)
+	require.Equal(t, int32(3), flaky.calls.Load())
+}
+
+func TestLoggingAndTracingMiddleware(t *testing.T) {
+	ctx := context.Background()
+	logs := &recordingLogger{messages: map[string][]string{}}
+	var ended []error
+	type spanKey struct{}
+	tracer := func(ctx context.Context, op Operation) (context.Context, func(err error)) {
+		return context.WithValue(ctx, spanKey{}, "cache."+op.Name), func(err error) {
+			ended = append(ended, err)
+		}
+	}
+	flaky := &flakyCache{Cache: newMemForTest(t), err: errors.New("connection reset")}
+	flaky.failures.Store(1)
+	c := Chain(flaky, LoggingMiddleware(logs), TracingMiddleware(tracer))
+
+	_, err := c.GetKey(ctx, "nop", "p1")
+	require.Error(t, err)
+	_, err = c.GetKey(ctx, "nop", "p1")
+	require.ErrorIs(t, err, NotFoundError)
+
+	require.Equal(t, []string{"cache operation failed"}, logs.messages["error"])
+	require.Len(t, logs.messages["debug"], 1)
+	require.Len(t, ended, 2)
+	require.Error(t, ended[0])
+	require.NoError(t, ended[1])
+}
+
+func TestMiddlewareUnwrap(t *testing.T) {
+	srv := miniredis.RunT(t)
+	rd, err := NewRedisCache(WithAddresses(nil, srv.Addr()))
+	require.NoError(t, err)
+	c := Chain(rd, MetricsMiddleware(metrictest.NewRecorder(), "redis"), TimeoutMiddleware(time.Second))
+
+	_, err = RedisClient(c)
+	require.NoError(t, err)
+	_, err = NewLocker(c)
+	require.NoError(t, err)
+}
+
+func TestDecoratorUnwrap(t *testing.T) {
+	srv := miniredis.RunT(t)
+	rd, err := NewRedisCache(WithAddresses(nil, srv.Addr()))
+	require.NoError(t, err)
+	negative, err := NewNegativeCache(rd, time.Minute)
+	require.NoError(t, err)
+	secure, err := NewSecureCache(negative, WithEncryptionKey("k1", bytes.Repeat([]byte("k"), 32)))
+	require.NoError(t, err)
+	near, err := NewNearCache(secure)
+	require.NoError(t, err)
+	defer near.Close()
+	c := Chain(near, MetricsMiddleware(metrictest.NewRecorder(), "near"), TimeoutMiddleware(time.Second))
+
+	client, err := RedisClient(c)
+	require.NoError(t, err)
+	require.NoError(t, client.Ping(context.Background()).Err())
+	_, err = NewLocker(c)
+	require.NoError(t, err)
+	_, err = DeleteByPattern(context.Background(), c, "nop", "product:*", 10)
+	require.NoError(t, err)
+}
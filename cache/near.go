@@ -0,0 +1,309 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"github.com/Electronic-Catalog/microkit/logger"
+	"github.com/Electronic-Catalog/microkit/logger/keyval"
+	"github.com/Electronic-Catalog/microkit/logger/zap"
+	"github.com/Electronic-Catalog/microkit/metric"
+	"github.com/go-redis/redis/v8"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultInvalidationChannel = "microkit:cache:invalidate"
+
+type nearCache struct {
+	local *memCache
+	// remote is called as it is given, so its middlewares and breaker apply to L2 calls,
+	// backend is the redis cache beneath it which gives the client and key prefix for invalidations
+	remote          Cache
+	backend         *redisCache
+	metric          metric.Metric
+	reqResLogger    logger.Logger
+	localTTL        time.Duration
+	maxLocalEntries int
+	channel         string
+	pubSub          *redis.PubSub
+	done            chan struct{}
+
+	// lock guards fills and orders L1 fills after invalidations of their keys
+	lock  *sync.Mutex
+	fills map[string]*nearFill
+}
+
+// nearFill
+// tracks L1 fills of a key which are in progress, an invalidation of the key changes its generation,
+// so a fill which read L2 before the invalidation doesn't bring the old value back to L1
+type nearFill struct {
+	readers    int
+	generation uint64
+}
+
+// NewNearCache
+// puts an in-process cache (L1) in front of the given redis cache (L2), hot keys are served from memory.
+// remote may be wrapped by middlewares or a circuit breaker, they still apply to calls of L2.
+// Set and RemoveKey are broadcast over redis pub/sub, so other replicas drop their L1 copy of the key.
+// an L1 copy lives at most for local ttl, which bounds staleness when an invalidation message is lost.
+// call Close to stop listening to invalidations
+func NewNearCache(remote Cache, options ...Option) (Cache, error) {
+	rd, ok := unwrap(remote).(*redisCache)
+	if !ok {
+		return nil, errors.New("near cache needs a redis cache (NewRedisCache) as remote tier")
+	}
+
+	nc := nearCache{
+		remote:          remote,
+		backend:         rd,
+		metric:          metric.NewNop(),
+		reqResLogger:    zap.NopLogger,
+		localTTL:        time.Second * 30,
+		maxLocalEntries: 10000,
+		channel:         defaultInvalidationChannel,
+		done:            make(chan struct{}),
+		lock:            &sync.Mutex{},
+		fills:           make(map[string]*nearFill),
+	}
+
+	for _, op := range options {
+		err := op(&nc)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	local, err := NewInMemoryCache(time.Second*10, WithMaxEntries(nc.maxLocalEntries), WithEvictionPolicy(LRU))
+	if err != nil {
+		return nil, err
+	}
+	nc.local = local.(*memCache)
+
+	nc.pubSub = rd.client.Subscribe(context.Background(), nc.channel)
+	// wait for subscription confirmation, otherwise early invalidations would be lost
+	_, err = nc.pubSub.Receive(context.Background())
+	if err != nil {
+		_ = nc.pubSub.Close()
+		return nil, err
+	}
+
+	go nc.invalidationProcess()
+
+	return &nc, nil
+}
+
+func (n *nearCache) Ping(ctx context.Context) error {
+	return n.remote.Ping(ctx)
+}
+
+func (n *nearCache) GetKey(ctx context.Context, method string, key string) (string, error) {
+	val, err := n.local.GetKey(ctx, method, key)
+	if err == nil {
+		n.metric.IncrementTotal("near", "l1", method, "hit")
+		return val, nil
+	}
+	n.metric.IncrementTotal("near", "l1", method, "miss")
+
+	generation := n.startFill(key)
+	val, err = n.remote.GetKey(ctx, method, key)
+	if errors.Is(err, NotFoundError) {
+		n.finishFill(ctx, method, key, generation, nil)
+		n.metric.IncrementTotal("near", "l2", method, "miss")
+		return "", err
+	} else if err != nil {
+		n.finishFill(ctx, method, key, generation, nil)
+		n.metric.IncrementTotal("near", "l2", method, operationOutcome(err))
+		n.metric.IncrementError("near", "l2", method, errorKind(err))
+		return "", err
+	}
+	n.metric.IncrementTotal("near", "l2", method, "hit")
+	n.finishFill(ctx, method, key, generation, &val)
+
+	return val, nil
+}
+
+// startFill registers a read of key from L2 and returns the invalidation generation it has to keep
+func (n *nearCache) startFill(key string) uint64 {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	fill, ok := n.fills[key]
+	if !ok {
+		fill = &nearFill{}
+		n.fills[key] = fill
+	}
+	fill.readers++
+
+	return fill.generation
+}
+
+// finishFill
+// stores val in L1 unless key is invalidated since startFill, a nil val only ends the fill
+func (n *nearCache) finishFill(ctx context.Context, method string, key string, generation uint64, val *string) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	fill := n.fills[key]
+	fill.readers--
+	if fill.readers == 0 {
+		delete(n.fills, key)
+	}
+
+	if val != nil && fill.generation == generation {
+		// L1 is bounded, least recently used keys are evicted when it is full
+		_ = n.local.Set(ctx, method, key, *val, n.localTTL)
+	}
+}
+
+// dropLocal
+// removes the L1 copy of key and invalidates fills of it which are in progress
+func (n *nearCache) dropLocal(ctx context.Context, method string, key string) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if fill, ok := n.fills[key]; ok {
+		fill.generation++
+	}
+	_ = n.local.RemoveKey(ctx, method, key)
+}
+
+// dropAllLocal flushes L1 and invalidates every fill which is in progress
+func (n *nearCache) dropAllLocal() {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	for _, fill := range n.fills {
+		fill.generation++
+	}
+	n.local.flush()
+}
+
+func (n *nearCache) Set(ctx context.Context, method string, key string, val string, expiration time.Duration) error {
+	err := n.remote.Set(ctx, method, key, val, expiration)
+	if err != nil {
+		return err
+	}
+
+	return n.invalidate(ctx, method, key)
+}
+
+func (n *nearCache) RemoveKey(ctx context.Context, method string, key string) error {
+	err := n.remote.RemoveKey(ctx, method, key)
+	if err != nil {
+		return err
+	}
+
+	return n.invalidate(ctx, method, key)
+}
+
+func (n *nearCache) Incr(ctx context.Context, method string, key string) (int64, error) {
+	return n.IncrBy(ctx, method, key, 1)
+}
+
+// IncrBy
+// counters are changed on remote, local copies of them are dropped in all replicas
+func (n *nearCache) IncrBy(ctx context.Context, method string, key string, delta int64) (int64, error) {
+	val, err := n.remote.IncrBy(ctx, method, key, delta)
+	if err != nil {
+		return 0, err
+	}
+
+	return val, n.invalidate(ctx, method, key)
+}
+
+func (n *nearCache) Decr(ctx context.Context, method string, key string) (int64, error) {
+	return n.IncrBy(ctx, method, key, -1)
+}
+
+func (n *nearCache) SetNX(ctx context.Context, method string, key string, val string, expiration time.Duration) (bool, error) {
+	stored, err := n.remote.SetNX(ctx, method, key, val, expiration)
+	if err != nil || !stored {
+		return stored, err
+	}
+
+	return true, n.invalidate(ctx, method, key)
+}
+
+// TTL
+// local copies live at most for local ttl, so only remote knows the real expiration
+func (n *nearCache) TTL(ctx context.Context, method string, key string) (time.Duration, error) {
+	return n.remote.TTL(ctx, method, key)
+}
+
+// Expire
+// local copies are dropped, so a shortened expiration is not outlived by them
+func (n *nearCache) Expire(ctx context.Context, method string, key string, expiration time.Duration) error {
+	err := n.remote.Expire(ctx, method, key, expiration)
+	if err != nil {
+		return err
+	}
+
+	return n.invalidate(ctx, method, key)
+}
+
+// Close
+// stops listening to invalidation messages of other replicas and drops the local tier,
+// the remote cache is not closed because it is owned by the caller
+func (n *nearCache) Close() error {
+	select {
+	case <-n.done:
+		return nil
+	default:
+		close(n.done)
+	}
+
+	_ = n.local.Close()
+	return n.pubSub.Close()
+}
+
+// Unwrap
+// returns remote, so helpers which need a specific backend (e.g. RedisClient) see through the local tier
+func (n *nearCache) Unwrap() Cache {
+	return n.remote
+}
+
+// invalidate
+// drops the local copy and tells other replicas to drop theirs
+func (n *nearCache) invalidate(ctx context.Context, method string, key string) error {
+	n.dropLocal(ctx, method, key)
+
+	// replicas of other namespaces or schema versions may share the channel, so the stored key is published
+	err := n.backend.client.Publish(ctx, n.channel, n.backend.key(key)).Err()
+	if err != nil {
+		n.metric.IncrementTotal("near", "publish", method, operationOutcome(err))
+		n.metric.IncrementError("near", "publish", method, errorKind(err))
+		return err
+	}
+
+	return nil
+}
+
+func (n *nearCache) invalidationProcess() {
+	ch := n.pubSub.ChannelWithSubscriptions(context.Background(), 1000)
+
+	for {
+		select {
+		case <-n.done:
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+
+			switch m := msg.(type) {
+			case *redis.Message:
+				if !strings.HasPrefix(m.Payload, n.backend.prefix) {
+					// key of another namespace or schema version
+					continue
+				}
+				n.dropLocal(context.Background(), "invalidate", strings.TrimPrefix(m.Payload, n.backend.prefix))
+			case *redis.Subscription:
+				// we are re-subscribed after a connection loss and may have missed invalidations
+				n.reqResLogger.Warn("near cache re-subscribed to invalidation channel, dropping local tier",
+					keyval.String("channel", m.Channel))
+				n.dropAllLocal()
+			}
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package cache
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestNearCache(t *testing.T) {
+	srv := miniredis.RunT(t)
+	ctx := context.Background()
+
+	newNear := func() Cache {
+		rd, err := NewRedisCache(WithAddresses(nil, srv.Addr()))
+		require.NoError(t, err)
+		nc, err := NewNearCache(rd, WithLocalTTL(time.Minute))
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = nc.Close()
+		})
+		return nc
+	}
+
+	replicaA := newNear()
+	replicaB := newNear()
+
+	// the first value is written without an invalidation, which could reach replica B after it filled L1
+	require.NoError(t, srv.Set("product:1", "v1"))
+
+	val, err := replicaB.GetKey(ctx, "nop", "product:1")
+	require.NoError(t, err)
+	require.Equal(t, "v1", val)
+
+	// L1 of replica B serves the key even when redis changes behind its back
+	require.NoError(t, srv.Set("product:1", "changed-directly"))
+	val, err = replicaB.GetKey(ctx, "nop", "product:1")
+	require.NoError(t, err)
+	require.Equal(t, "v1", val)
+
+	// a Set on replica A drops the L1 copy of replica B
+	require.NoError(t, replicaA.Set(ctx, "nop", "product:1", "v2", time.Minute))
+	require.Eventually(t, func() bool {
+		val, err := replicaB.GetKey(ctx, "nop", "product:1")
+		return err == nil && val == "v2"
+	}, time.Second, time.Millisecond*10)
+
+	require.NoError(t, replicaA.RemoveKey(ctx, "nop", "product:1"))
+	require.Eventually(t, func() bool {
+		_, err := replicaB.GetKey(ctx, "nop", "product:1")
+		return err == NotFoundError
+	}, time.Second, time.Millisecond*10)
+}
+
+func TestNearCacheInvalidationDuringFill(t *testing.T) {
+	srv := miniredis.RunT(t)
+	ctx := context.Background()
+
+	writer, err := NewRedisCache(WithAddresses(nil, srv.Addr()))
+	require.NoError(t, err)
+	replicaA, err := NewNearCache(writer)
+	require.NoError(t, err)
+	defer replicaA.Close()
+
+	// L2 reads of replica B wait for the test after they got the value, the remote is wrapped by a middleware
+	rd, err := NewRedisCache(WithAddresses(nil, srv.Addr()))
+	require.NoError(t, err)
+	read := make(chan struct{})
+	release := make(chan struct{})
+	remote := Chain(rd, Intercept(func(ctx context.Context, op Operation, next Invoker) error {
+		err := next(ctx)
+		if op.Name == "get" {
+			read <- struct{}{}
+			<-release
+		}
+		return err
+	}))
+	c, err := NewNearCache(remote, WithLocalTTL(time.Minute))
+	require.NoError(t, err)
+	defer c.Close()
+	replicaB := c.(*nearCache)
+
+	require.NoError(t, srv.Set("product:1", "v1"))
+	done := make(chan string)
+	go func() {
+		val, err := replicaB.GetKey(ctx, "nop", "product:1")
+		assert.NoError(t, err)
+		done <- val
+	}()
+	<-read
+
+	// replica A changes the key while replica B holds the old value and hasn't filled L1 yet
+	require.NoError(t, replicaA.Set(ctx, "nop", "product:1", "v2", time.Minute))
+	require.Eventually(t, func() bool {
+		replicaB.lock.Lock()
+		defer replicaB.lock.Unlock()
+		return replicaB.fills["product:1"].generation > 0
+	}, time.Second, time.Millisecond*10)
+	close(release)
+	require.Equal(t, "v1", <-done)
+
+	_, err = replicaB.local.GetKey(ctx, "nop", "product:1")
+	require.ErrorIs(t, err, NotFoundError)
+	go func() {
+		<-read
+	}()
+	val, err := replicaB.GetKey(ctx, "nop", "product:1")
+	require.NoError(t, err)
+	require.Equal(t, "v2", val)
+}
@@ -0,0 +1,118 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Electronic-Catalog/microkit/metric"
+	"time"
+)
+
+// negativeValue
+// is stored for keys which are known to be missing, control characters keep it apart from real values
+const negativeValue = "\x00microkit:known-missing\x00"
+
+// NegativeCache
+// caches absence of keys, GetKey returns KnownMissingError for keys which are stored by SetMissing
+// and NotFoundError for keys which are not cached at all
+type NegativeCache interface {
+	Cache
+	// SetMissing
+	// to remember that key doesn't exist in the source of truth for the negative ttl
+	// method :: used for metrics
+	SetMissing(ctx context.Context, method string, key string) error
+}
+
+type negativeCache struct {
+	Cache
+	ttl    time.Duration
+	metric metric.Metric
+}
+
+// NewNegativeCache
+// wraps cache with negative caching, ttl is usually much shorter than ttl of values,
+// so a created item is not hidden for long
+func NewNegativeCache(cache Cache, ttl time.Duration, options ...Option) (NegativeCache, error) {
+	if ttl <= 0 {
+		return nil, fmt.Errorf("negative ttl must be positive, got %v", ttl)
+	}
+
+	nc := negativeCache{
+		Cache:  cache,
+		ttl:    ttl,
+		metric: metric.NewNop(),
+	}
+
+	for _, op := range options {
+		err := op(&nc)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &nc, nil
+}
+
+// record records an operation with the metric schema of backends, err points to the result of operation
+func (n *negativeCache) record(op string, method string, start time.Time, err *error) {
+	recordOperation(n.metric, "negative", op, method, start, *err)
+}
+
+func (n *negativeCache) GetKey(ctx context.Context, method string, key string) (val string, err error) {
+	defer n.record("get", method, time.Now(), &err)
+
+	val, err = n.Cache.GetKey(ctx, method, key)
+	if err != nil {
+		return "", err
+	}
+	if val == negativeValue {
+		return "", KnownMissingError
+	}
+
+	return val, nil
+}
+
+func (n *negativeCache) SetMissing(ctx context.Context, method string, key string) (err error) {
+	defer n.record("set_missing", method, time.Now(), &err)
+
+	return n.Cache.Set(ctx, method, key, negativeValue, n.ttl)
+}
+
+// MGet
+// known missing keys are not present in the result like missed ones, each of them is counted
+// as a known_missing outcome of mget
+func (n *negativeCache) MGet(ctx context.Context, method string, keys ...string) (map[string]string, error) {
+	result, err := MGet(ctx, n.Cache, method, keys...)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, val := range result {
+		if val == negativeValue {
+			n.metric.IncrementTotal("negative", "mget", method, OutcomeKnownMissing)
+			delete(result, key)
+		}
+	}
+
+	return result, nil
+}
+
+func (n *negativeCache) MSet(ctx context.Context, method string, items map[string]string, expiration time.Duration) error {
+	return MSet(ctx, n.Cache, method, items, expiration)
+}
+
+func (n *negativeCache) MDel(ctx context.Context, method string, keys ...string) error {
+	return MDel(ctx, n.Cache, method, keys...)
+}
+
+// Unwrap
+// returns the wrapped cache, so helpers which need a specific backend (e.g. RedisClient) see through negative caching
+func (n *negativeCache) Unwrap() Cache {
+	return n.Cache
+}
+
+// isKnownMissing reports whether a GetKey result means the key is known to be missing,
+// caches which are not wrapped by NewNegativeCache return the stored sentinel itself
+func isKnownMissing(val string, err error) bool {
+	return errors.Is(err, KnownMissingError) || (err == nil && val == negativeValue)
+}
@@ -0,0 +1,98 @@
+package cache
+
+import (
+	"context"
+	"github.com/Electronic-Catalog/microkit/metric/metrictest"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/require"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNegativeCache(t *testing.T) {
+	srv := miniredis.RunT(t)
+	rd, err := NewRedisCache(WithAddresses(nil, srv.Addr()))
+	require.NoError(t, err)
+	mem, err := NewInMemoryCache(time.Second)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	for name, c := range map[string]Cache{"redis": rd, "mem": mem} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			mt := metrictest.NewRecorder()
+			nc, err := NewNegativeCache(c, time.Second*5, WithMetricOption(mt))
+			require.NoError(t, err)
+
+			_, err = nc.GetKey(ctx, "nop", "product:404")
+			require.ErrorIs(t, err, NotFoundError)
+
+			require.NoError(t, nc.SetMissing(ctx, "nop", "product:404"))
+			_, err = nc.GetKey(ctx, "nop", "product:404")
+			require.ErrorIs(t, err, KnownMissingError)
+			require.NotErrorIs(t, err, NotFoundError)
+			require.Equal(t, 1, mt.Total("negative", "get", "nop", OutcomeKnownMissing))
+			require.Equal(t, 1, mt.Total("negative", "get", "nop", OutcomeMiss))
+			require.Equal(t, 1, mt.Total("negative", "set_missing", "nop", OutcomeHit))
+
+			ttl, err := nc.TTL(ctx, "nop", "product:404")
+			require.NoError(t, err)
+			require.LessOrEqual(t, ttl, time.Second*5)
+
+			require.NoError(t, nc.Set(ctx, "nop", "product:1", "p1", time.Minute))
+			got, err := MGet(ctx, nc, "nop", "product:1", "product:404")
+			require.NoError(t, err)
+			require.Equal(t, map[string]string{"product:1": "p1"}, got)
+			require.Equal(t, 1, mt.Total("negative", "mget", "nop", OutcomeKnownMissing))
+
+			// a created item replaces the negative entry
+			require.NoError(t, nc.Set(ctx, "nop", "product:404", "p404", time.Minute))
+			val, err := nc.GetKey(ctx, "nop", "product:404")
+			require.NoError(t, err)
+			require.Equal(t, "p404", val)
+		})
+	}
+
+	_, err = NewNegativeCache(mem, 0)
+	require.Error(t, err)
+}
+
+func TestReadThroughNegativeTTL(t *testing.T) {
+	srv := miniredis.RunT(t)
+	rd, err := NewRedisCache(WithAddresses(nil, srv.Addr()))
+	require.NoError(t, err)
+	mt := metrictest.NewRecorder()
+	rt := NewReadThrough(rd, WithNegativeTTL(time.Second*5), WithReadThroughMetric(mt))
+	ctx := context.Background()
+
+	var calls int32
+	loader := func(ctx context.Context) (string, error) {
+		atomic.AddInt32(&calls, 1)
+		return "", NotFoundError
+	}
+
+	for i := 0; i < 3; i++ {
+		_, err := rt.GetOrLoad(ctx, "nop", "product:404", time.Minute, loader)
+		require.ErrorIs(t, err, KnownMissingError)
+	}
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	require.Equal(t, 1, mt.Total("readthrough", "load", "nop", OutcomeMiss))
+	require.Equal(t, 2, mt.Total("readthrough", "get", "nop", OutcomeKnownMissing))
+
+	// other caches see the negative entry through NewNegativeCache
+	nc, err := NewNegativeCache(rd, time.Second*5)
+	require.NoError(t, err)
+	_, err = nc.GetKey(ctx, "nop", "product:404")
+	require.ErrorIs(t, err, KnownMissingError)
+
+	srv.FastForward(time.Second * 6)
+	_, err = rt.GetOrLoad(ctx, "nop", "product:404", time.Minute, loader)
+	require.ErrorIs(t, err, KnownMissingError)
+	require.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// without negative ttl the loader error is returned as is
+	plain := NewReadThrough(rd)
+	_, err = plain.GetOrLoad(ctx, "nop", "product:405", time.Minute, loader)
+	require.ErrorIs(t, err, NotFoundError)
+}
@@ -0,0 +1,223 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"github.com/Electronic-Catalog/microkit/metric"
+	"golang.org/x/sync/singleflight"
+	"time"
+)
+
+// LoaderFunc
+// loads the value of a missed key from the source of truth (e.g. database)
+type LoaderFunc func(ctx context.Context) (string, error)
+
+type readThroughConfig struct {
+	metric       metric.Metric
+	locker       Locker
+	lockTTL      time.Duration
+	pollInterval time.Duration
+	negativeTTL  time.Duration
+	loadTimeout  time.Duration
+}
+
+type ReadThroughOption func(*readThroughConfig)
+
+// WithReadThroughMetric
+// reports get, load and set ops of each method with the metric schema of backends (see OutcomeHit),
+// outcomes of get are hit, miss, known_missing and error
+func WithReadThroughMetric(metric metric.Metric) ReadThroughOption {
+	return func(rc *readThroughConfig) {
+		rc.metric = metric
+	}
+}
+
+// WithLoadLock
+// enables a short lock so concurrent misses of the same key in different processes run the loader once,
+// the other processes wait for the value at most for ttl and load it themselves if it did not show up.
+// the lock is taken on the redis backend of cache unless another locker is given by WithLoadLocker
+func WithLoadLock(ttl time.Duration) ReadThroughOption {
+	return func(rc *readThroughConfig) {
+		rc.lockTTL = ttl
+	}
+}
+
+// WithLoadLocker
+// the locker which is used by WithLoadLock
+func WithLoadLocker(locker Locker) ReadThroughOption {
+	return func(rc *readThroughConfig) {
+		rc.locker = locker
+	}
+}
+
+// WithNegativeTTL
+// remembers keys which loader reported missing by returning NotFoundError for ttl, so they don't reach
+// database on every request, GetOrLoad returns KnownMissingError for them
+func WithNegativeTTL(ttl time.Duration) ReadThroughOption {
+	return func(rc *readThroughConfig) {
+		rc.negativeTTL = ttl
+	}
+}
+
+// WithLoadTimeout
+// bounds a shared load, 10s by default. a load is shared by all concurrent misses of a key, so it is not
+// canceled with the context of the caller which started it, non-positive values keep the default
+func WithLoadTimeout(timeout time.Duration) ReadThroughOption {
+	return func(rc *readThroughConfig) {
+		if timeout > 0 {
+			rc.loadTimeout = timeout
+		}
+	}
+}
+
+// ReadThrough
+// reads keys from cache and loads missed ones through a loader, concurrent misses of a key
+// share a single loader call, so an expiring popular key doesn't send a thundering herd to database
+type ReadThrough struct {
+	cache        Cache
+	group        singleflight.Group
+	metric       metric.Metric
+	locker       Locker
+	lockTTL      time.Duration
+	pollInterval time.Duration
+	negativeTTL  time.Duration
+	loadTimeout  time.Duration
+}
+
+func NewReadThrough(cache Cache, options ...ReadThroughOption) *ReadThrough {
+	conf := readThroughConfig{
+		metric:       metric.NewNop(),
+		pollInterval: time.Millisecond * 50,
+		loadTimeout:  time.Second * 10,
+	}
+	for _, op := range options {
+		op(&conf)
+	}
+
+	if conf.locker == nil && conf.lockTTL > 0 {
+		// in-process misses are already coordinated by singleflight, so only redis is worth locking
+		if rd, ok := unwrap(cache).(*redisCache); ok {
+			conf.locker = newRedisLocker(rd)
+		}
+	}
+
+	return &ReadThrough{
+		cache:        cache,
+		metric:       conf.metric,
+		locker:       conf.locker,
+		lockTTL:      conf.lockTTL,
+		pollInterval: conf.pollInterval,
+		negativeTTL:  conf.negativeTTL,
+		loadTimeout:  conf.loadTimeout,
+	}
+}
+
+// GetOrLoad
+// returns the cached value of key, on miss it calls loader and stores the result for ttl
+// method :: used for metrics
+func (r *ReadThrough) GetOrLoad(ctx context.Context, method string, key string, ttl time.Duration, loader LoaderFunc) (string, error) {
+	start := time.Now()
+	val, err := r.get(ctx, method, key)
+	recordOperation(r.metric, "readthrough", "get", method, start, err)
+	if err == nil {
+		return val, nil
+	} else if errors.Is(err, KnownMissingError) {
+		return "", err
+	}
+	// on errors other than miss the cache is not available, we still are able to serve from loader
+
+	// the load outlives a caller which gives up, the other waiters of key still receive its result
+	ch := r.group.DoChan(key, func() (interface{}, error) {
+		loadCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), r.loadTimeout)
+		defer cancel()
+		return r.load(loadCtx, method, key, ttl, loader)
+	})
+
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return "", res.Err
+		}
+		return res.Val.(string), nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func (r *ReadThrough) load(ctx context.Context, method string, key string, ttl time.Duration, loader LoaderFunc) (string, error) {
+	if r.locker != nil && r.lockTTL > 0 {
+		token, err := r.locker.Acquire(ctx, "load:"+key, r.lockTTL)
+		if errors.Is(err, LockNotAcquiredError) {
+			// another process is loading the key
+			if val, err := r.wait(ctx, method, key); err == nil || errors.Is(err, KnownMissingError) {
+				return val, err
+			}
+		} else if err == nil {
+			defer func() {
+				_ = r.locker.Release(context.Background(), "load:"+key, token)
+			}()
+
+			// the value may have been stored between our miss and acquiring the lock
+			if val, err := r.get(ctx, method, key); err == nil || errors.Is(err, KnownMissingError) {
+				return val, err
+			}
+		}
+	}
+
+	start := time.Now()
+	val, err := loader(ctx)
+	recordOperation(r.metric, "readthrough", "load", method, start, err)
+	if errors.Is(err, NotFoundError) && r.negativeTTL > 0 {
+		r.set(ctx, method, key, negativeValue, r.negativeTTL)
+		return "", KnownMissingError
+	} else if err != nil {
+		return "", err
+	}
+
+	r.set(ctx, method, key, val, ttl)
+
+	return val, nil
+}
+
+// set
+// stores a loaded value, its failure is only recorded, the value is still returned and loaded again by the next miss
+func (r *ReadThrough) set(ctx context.Context, method string, key string, val string, ttl time.Duration) {
+	start := time.Now()
+	err := r.cache.Set(ctx, method, key, val, ttl)
+	recordOperation(r.metric, "readthrough", "set", method, start, err)
+}
+
+// get
+// reads key from cache, keys which are cached as missing are reported by KnownMissingError
+func (r *ReadThrough) get(ctx context.Context, method string, key string) (string, error) {
+	val, err := r.cache.GetKey(ctx, method, key)
+	if isKnownMissing(val, err) {
+		return "", KnownMissingError
+	}
+
+	return val, err
+}
+
+// wait
+// polls cache until the lock holder stores the value or lock ttl passes, NotFoundError is returned
+// when the value didn't show up
+func (r *ReadThrough) wait(ctx context.Context, method string, key string) (string, error) {
+	ticker := time.NewTicker(r.pollInterval)
+	defer ticker.Stop()
+	deadline := time.NewTimer(r.lockTTL)
+	defer deadline.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return "", NotFoundError
+		case <-deadline.C:
+			return "", NotFoundError
+		case <-ticker.C:
+			val, err := r.get(ctx, method, key)
+			if err == nil || errors.Is(err, KnownMissingError) {
+				return val, err
+			}
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReadThroughSingleFlight(t *testing.T) {
+	mem, err := NewInMemoryCache(time.Second)
+	require.NoError(t, err)
+	rt := NewReadThrough(mem)
+	ctx := context.Background()
+
+	var calls int32
+	loader := func(ctx context.Context) (string, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(time.Millisecond * 100)
+		return "loaded", nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			val, err := rt.GetOrLoad(ctx, "nop", "popular", time.Minute, loader)
+			assert.NoError(t, err)
+			assert.Equal(t, "loaded", val)
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// served from cache
+	val, err := rt.GetOrLoad(ctx, "nop", "popular", time.Minute, loader)
+	require.NoError(t, err)
+	require.Equal(t, "loaded", val)
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestReadThroughCanceledCaller(t *testing.T) {
+	mem, err := NewInMemoryCache(time.Second)
+	require.NoError(t, err)
+	defer mem.Close()
+	rt := NewReadThrough(mem)
+
+	started := make(chan struct{})
+	loader := func(ctx context.Context) (string, error) {
+		close(started)
+		select {
+		case <-time.After(time.Millisecond * 100):
+			return "loaded", nil
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+
+	// the caller which starts the load gives up, the other waiter still gets the value
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := rt.GetOrLoad(ctx, "nop", "popular", time.Minute, loader)
+		first <- err
+	}()
+	<-started
+
+	second := make(chan string, 1)
+	go func() {
+		val, err := rt.GetOrLoad(context.Background(), "nop", "popular", time.Minute, loader)
+		assert.NoError(t, err)
+		second <- val
+	}()
+	time.Sleep(time.Millisecond * 10)
+	cancel()
+
+	require.ErrorIs(t, <-first, context.Canceled)
+	require.Equal(t, "loaded", <-second)
+	val, err := mem.GetKey(context.Background(), "nop", "popular")
+	require.NoError(t, err)
+	require.Equal(t, "loaded", val)
+}
+
+func TestReadThroughLoaderError(t *testing.T) {
+	mem, err := NewInMemoryCache(time.Second)
+	require.NoError(t, err)
+	rt := NewReadThrough(mem)
+	ctx := context.Background()
+
+	loadErr := errors.New("database is down")
+	_, err = rt.GetOrLoad(ctx, "nop", "key", time.Minute, func(ctx context.Context) (string, error) {
+		return "", loadErr
+	})
+	require.ErrorIs(t, err, loadErr)
+
+	_, err = mem.GetKey(ctx, "nop", "key")
+	require.ErrorIs(t, err, NotFoundError)
+}
+
+func TestReadThroughLoadLock(t *testing.T) {
+	srv := miniredis.RunT(t)
+	ctx := context.Background()
+
+	var calls int32
+	loader := func(ctx context.Context) (string, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(time.Millisecond * 200)
+		return "loaded", nil
+	}
+
+	// every read-through has its own singleflight group, like separate processes
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		rd, err := NewRedisCache(WithAddresses(nil, srv.Addr()))
+		require.NoError(t, err)
+		rt := NewReadThrough(rd, WithLoadLock(time.Second))
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			val, err := rt.GetOrLoad(ctx, "nop", "popular", time.Minute, loader)
+			assert.NoError(t, err)
+			assert.Equal(t, "loaded", val)
+		}()
+	}
+	wg.Wait()
+
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	require.False(t, srv.Exists(lockKey("load:popular")))
+}
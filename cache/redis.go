@@ -7,20 +7,31 @@ import (
 	"github.com/Electronic-Catalog/microkit/logger/zap"
 	"github.com/Electronic-Catalog/microkit/metric"
 	"github.com/go-redis/redis/v8"
+	"strings"
+	"sync"
 	"time"
 )
 
 type redisCache struct {
-	client               *redis.Client
+	client               redis.UniversalClient
 	metric               metric.Metric
 	reqResLogger         logger.Logger
 	singleInstanceOption *redis.Options
 	failOverOption       *redis.FailoverOptions
+	clusterOption        *redis.ClusterOptions
+	// fromConnectionString is set by WithConnectionStringOption, whose settings don't apply to cluster clients
+	fromConnectionString bool
+
+	// namespace and schema version of keys, prefix is built from them and prepended to every key
+	namespace     string
+	schemaVersion int
+	prefix        string
 }
 
 // NewRedisCache
 // in order to use single redis, just give single address in redis addresses and for enabling redis
-// sentinel mode, give cluster master-name and list of cluster members addresses
+// sentinel mode, give cluster master-name and list of cluster members addresses.
+// for redis cluster use WithClusterAddresses option
 func NewRedisCache(options ...Option) (Cache, error) {
 	repo := redisCache{
 		client:               nil,
@@ -28,6 +39,7 @@ func NewRedisCache(options ...Option) (Cache, error) {
 		reqResLogger:         zap.NopLogger,
 		singleInstanceOption: nil,
 		failOverOption:       nil,
+		clusterOption:        nil,
 	}
 
 	for _, op := range options { // configure redis cache with options
@@ -37,7 +49,23 @@ func NewRedisCache(options ...Option) (Cache, error) {
 		}
 	}
 
-	if repo.singleInstanceOption != nil {
+	if repo.fromConnectionString && repo.clusterOption != nil {
+		return nil, fmt.Errorf("WithConnectionStringOption can not be combined with WithClusterAddresses")
+	}
+
+	repo.prefix = keyPrefix(repo.namespace, repo.schemaVersion)
+
+	if repo.clusterOption != nil {
+		// set defaults
+		if repo.clusterOption.DialTimeout == 0 {
+			repo.clusterOption.DialTimeout = time.Second * 10
+		}
+		if repo.clusterOption.MaxRetries == 0 {
+			repo.clusterOption.MaxRetries = 2
+		}
+
+		repo.client = redis.NewClusterClient(repo.clusterOption)
+	} else if repo.singleInstanceOption != nil {
 		// set defaults
 		if repo.singleInstanceOption.DialTimeout == 0 {
 			repo.singleInstanceOption.DialTimeout = time.Second * 10
@@ -58,7 +86,7 @@ func NewRedisCache(options ...Option) (Cache, error) {
 
 		repo.client = redis.NewFailoverClient(repo.failOverOption)
 	} else {
-		return nil, fmt.Errorf("unspecified redis connection addresses - you have to use WithAddresses() option or WithConnectionStringOption() option")
+		return nil, fmt.Errorf("unspecified redis connection addresses - you have to use WithAddresses(), WithClusterAddresses() or WithConnectionStringOption() option")
 	}
 
 	pingCtx, cf := context.WithTimeout(context.Background(), time.Second*10)
@@ -72,32 +100,59 @@ func NewRedisCache(options ...Option) (Cache, error) {
 	return &repo, nil
 }
 
-func (r *redisCache) GetKey(ctx context.Context, method string, key string) (string, error) {
-	r.metric.IncrementTotal("redis", method)
-	defer func(startTime time.Time) {
-		r.metric.ObserveResponseTime(time.Since(startTime), "redis", method)
-	}(time.Now())
+// RedisClient
+// returns the redis client of a cache which is created by NewRedisCache, so other packages
+// (e.g. rate limiter) are able to share its connections and configuration
+func RedisClient(cache Cache) (redis.UniversalClient, error) {
+	rd, ok := unwrap(cache).(*redisCache)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a redis cache", cache)
+	}
+
+	return rd.client, nil
+}
+
+// key returns the stored key of key in namespace of cache
+func (r *redisCache) key(key string) string {
+	return r.prefix + key
+}
+
+func (r *redisCache) keys(keys []string) []string {
+	if r.prefix == "" {
+		return keys
+	}
+
+	stored := make([]string, len(keys))
+	for i, key := range keys {
+		stored[i] = r.prefix + key
+	}
 
-	val, err := r.client.Get(ctx, key).Result()
+	return stored
+}
+
+// record records an operation with the metric schema of backends, err points to the result of operation
+func (r *redisCache) record(op string, method string, start time.Time, err *error) {
+	recordOperation(r.metric, "redis", op, method, start, *err)
+}
+
+func (r *redisCache) GetKey(ctx context.Context, method string, key string) (val string, err error) {
+	defer r.record("get", method, time.Now(), &err)
+
+	val, err = r.client.Get(ctx, r.key(key)).Result()
 	if err == redis.Nil {
 		return "", NotFoundError
 	} else if err != nil {
-		r.metric.IncrementError("redis", method, err.Error())
 		return "", err
 	}
 
 	return val, nil
 }
 
-func (r *redisCache) Set(ctx context.Context, method string, key string, val string, expiration time.Duration) error {
-	r.metric.IncrementTotal("redis", method)
-	defer func(startTime time.Time) {
-		r.metric.ObserveResponseTime(time.Since(startTime), "redis", method)
-	}(time.Now())
+func (r *redisCache) Set(ctx context.Context, method string, key string, val string, expiration time.Duration) (err error) {
+	defer r.record("set", method, time.Now(), &err)
 
-	err := r.client.Set(ctx, key, val, expiration).Err()
+	err = r.client.Set(ctx, r.key(key), val, expiration).Err()
 	if err != nil {
-		r.metric.IncrementError("redis", method, err.Error())
 		return err
 	}
 
@@ -117,17 +172,463 @@ func (r *redisCache) Ping(ctx context.Context) error {
 	return nil
 }
 
-func (r *redisCache) RemoveKey(ctx context.Context, method string, key string) error {
-	r.metric.IncrementTotal("redis", method)
-	defer func(startTime time.Time) {
-		r.metric.ObserveResponseTime(time.Since(startTime), "redis", method)
-	}(time.Now())
+func (r *redisCache) Close() error {
+	return r.client.Close()
+}
+
+func (r *redisCache) RemoveKey(ctx context.Context, method string, key string) (err error) {
+	defer r.record("del", method, time.Now(), &err)
+
+	err = r.client.Del(ctx, r.key(key)).Err()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *redisCache) MGet(ctx context.Context, method string, keys ...string) (result map[string]string, err error) {
+	defer r.record("mget", method, time.Now(), &err)
+
+	result = make(map[string]string, len(keys))
+	if len(keys) == 0 {
+		return result, nil
+	}
+
+	stored := r.keys(keys)
+	if _, ok := r.client.(*redis.ClusterClient); ok {
+		err := r.clusterMGet(ctx, stored, result)
+		if err != nil {
+			return nil, err
+		}
+
+		return result, nil
+	}
+
+	vals, err := r.client.MGet(ctx, stored...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	r.collectMGet(stored, vals, result)
+
+	return result, nil
+}
+
+// clusterMGet
+// a cluster rejects MGET of keys in different slots, so keys are grouped by slot
+// and one MGET per slot is pipelined, the cluster client routes each of them to its node
+func (r *redisCache) clusterMGet(ctx context.Context, keys []string, result map[string]string) error {
+	groups := groupBySlot(keys)
+	cmds := make(map[*redis.SliceCmd][]string, len(groups))
+
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, group := range groups {
+			cmds[pipe.MGet(ctx, group...)] = group
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for cmd, group := range cmds {
+		r.collectMGet(group, cmd.Val(), result)
+	}
+
+	return nil
+}
+
+// collectMGet
+// stores values of a MGET reply in result by their keys without namespace prefix
+func (r *redisCache) collectMGet(stored []string, vals []interface{}, result map[string]string) {
+	for i, val := range vals {
+		// missed keys are returned as nil
+		if str, ok := val.(string); ok {
+			result[strings.TrimPrefix(stored[i], r.prefix)] = str
+		}
+	}
+}
+
+func (r *redisCache) MSet(ctx context.Context, method string, items map[string]string, expiration time.Duration) (err error) {
+	defer r.record("mset", method, time.Now(), &err)
+
+	if len(items) == 0 {
+		return nil
+	}
+
+	// MSET doesn't accept expiration, so we pipeline SET commands in one round trip
+	_, err = r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for key, val := range items {
+			pipe.Set(ctx, r.key(key), val, expiration)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *redisCache) MDel(ctx context.Context, method string, keys ...string) (err error) {
+	defer r.record("mdel", method, time.Now(), &err)
+
+	if len(keys) == 0 {
+		return nil
+	}
+
+	_, err = r.deleteInBatches(ctx, r.keys(keys), defaultDeleteBatch)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *redisCache) Incr(ctx context.Context, method string, key string) (int64, error) {
+	return r.IncrBy(ctx, method, key, 1)
+}
+
+func (r *redisCache) IncrBy(ctx context.Context, method string, key string, delta int64) (val int64, err error) {
+	defer r.record("incr", method, time.Now(), &err)
+
+	val, err = r.client.IncrBy(ctx, r.key(key), delta).Result()
+	if err != nil {
+		if isNotIntegerError(err) {
+			return 0, NotIntegerError
+		}
+		return 0, err
+	}
+
+	return val, nil
+}
+
+func (r *redisCache) Decr(ctx context.Context, method string, key string) (int64, error) {
+	return r.IncrBy(ctx, method, key, -1)
+}
+
+func (r *redisCache) SetNX(ctx context.Context, method string, key string, val string, expiration time.Duration) (stored bool, err error) {
+	defer r.record("setnx", method, time.Now(), &err)
+
+	stored, err = r.client.SetNX(ctx, r.key(key), val, expiration).Result()
+	if err != nil {
+		return false, err
+	}
+
+	return stored, nil
+}
+
+func (r *redisCache) TTL(ctx context.Context, method string, key string) (ttl time.Duration, err error) {
+	defer r.record("ttl", method, time.Now(), &err)
+
+	ttl, err = r.client.PTTL(ctx, r.key(key)).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	// redis replies -2 for missed keys and -1 for keys without expiration
+	switch ttl {
+	case -2:
+		return 0, NotFoundError
+	case -1:
+		return NoExpiration, nil
+	}
+
+	return ttl, nil
+}
+
+// persistScript
+// PERSIST replies zero for both missed keys and keys without expiration, so existence is checked first
+var persistScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+redis.call("PERSIST", KEYS[1])
+return 1
+`)
+
+func (r *redisCache) Expire(ctx context.Context, method string, key string, expiration time.Duration) (err error) {
+	defer r.record("expire", method, time.Now(), &err)
+
+	var found bool
+	if expiration <= 0 {
+		var res int64
+		res, err = persistScript.Run(ctx, r.client, []string{r.key(key)}).Int64()
+		found = res == 1
+	} else {
+		found, err = r.client.PExpire(ctx, r.key(key), expiration).Result()
+	}
+	if err != nil {
+		return err
+	}
+	if !found {
+		return NotFoundError
+	}
+
+	return nil
+}
+
+// isNotIntegerError reports whether redis refused to increment a value which is not an integer
+// or would overflow
+func isNotIntegerError(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "not an integer") || strings.Contains(msg, "overflow")
+}
 
-	err := r.client.Del(ctx, key).Err()
+// tagScript
+// adds a key to a tag set and makes sure the set lives as long as its longest living member,
+// so the set is removed by redis after all of its members are expired.
+// when pruning is enabled a few random members are checked and expired ones are removed from set
+var tagScript = redis.NewScript(`
+local ttl = tonumber(ARGV[2])
+local existed = redis.call("EXISTS", KEYS[1])
+redis.call("SADD", KEYS[1], ARGV[1])
+
+if existed == 0 then
+	if ttl > 0 then
+		redis.call("PEXPIRE", KEYS[1], ttl)
+	end
+else
+	local current = redis.call("PTTL", KEYS[1])
+	if ttl == 0 then
+		redis.call("PERSIST", KEYS[1])
+	elseif current >= 0 and current < ttl then
+		redis.call("PEXPIRE", KEYS[1], ttl)
+	end
+end
+
+if ARGV[3] == "1" then
+	local sample = redis.call("SRANDMEMBER", KEYS[1], 5)
+	for _, member in ipairs(sample) do
+		if redis.call("EXISTS", member) == 0 then
+			redis.call("SREM", KEYS[1], member)
+		end
+	end
+end
+return 1
+`)
+
+// tagKey is the redis set which keeps keys of a tag
+func tagKey(tag string) string {
+	return "tag:" + tag
+}
+
+func (r *redisCache) SetWithTags(ctx context.Context, method string, key string, val string, expiration time.Duration, tags ...string) (err error) {
+	defer r.record("set_tags", method, time.Now(), &err)
+
+	// scripts may only touch keys of one slot in cluster mode, so members are not pruned there
+	prune := "1"
+	if _, ok := r.client.(*redis.ClusterClient); ok {
+		prune = "0"
+	}
+
+	_, err = r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, r.key(key), val, expiration)
+		for _, tag := range tags {
+			tagScript.Eval(ctx, pipe, []string{r.key(tagKey(tag))}, r.key(key), expiration.Milliseconds(), prune)
+		}
+		return nil
+	})
 	if err != nil {
-		r.metric.IncrementError("redis", method, err.Error())
 		return err
 	}
 
 	return nil
 }
+
+func (r *redisCache) InvalidateTags(ctx context.Context, method string, tags ...string) (err error) {
+	defer r.record("invalidate", method, time.Now(), &err)
+
+	for _, tag := range tags {
+		// reading members and removing the set is atomic, keys tagged after that go to a new set
+		var members *redis.StringSliceCmd
+		_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			members = pipe.SMembers(ctx, r.key(tagKey(tag)))
+			pipe.Del(ctx, r.key(tagKey(tag)))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		_, err = r.deleteInBatches(ctx, members.Val(), defaultDeleteBatch)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteInBatches
+// removes stored keys (with namespace prefix) with at most batchSize keys per command, so redis is not blocked by a huge DEL,
+// in cluster mode each command only contains keys of one slot because cluster rejects the others.
+// it returns number of removed keys
+func (r *redisCache) deleteInBatches(ctx context.Context, keys []string, batchSize int) (int64, error) {
+	_, isCluster := r.client.(*redis.ClusterClient)
+
+	var removed int64
+	for start := 0; start < len(keys); start += batchSize {
+		end := start + batchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		var cmds []*redis.IntCmd
+		_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			if isCluster {
+				for _, group := range groupBySlot(keys[start:end]) {
+					cmds = append(cmds, pipe.Del(ctx, group...))
+				}
+			} else {
+				cmds = append(cmds, pipe.Del(ctx, keys[start:end]...))
+			}
+			return nil
+		})
+		for _, cmd := range cmds {
+			removed += cmd.Val()
+		}
+		if err != nil {
+			return removed, err
+		}
+	}
+
+	return removed, nil
+}
+
+// Scan
+// uses SCAN with MATCH, in cluster mode masters are scanned one after another.
+// each SCAN round trip is recorded in metrics of method
+func (r *redisCache) Scan(ctx context.Context, method string, pattern string, count int64) KeyIterator {
+	return r.scan(ctx, method, pattern, count, r.metric)
+}
+
+func (r *redisCache) scan(ctx context.Context, method string, pattern string, count int64, m metric.Metric) *redisScanIterator {
+	it := &redisScanIterator{
+		ctx:    ctx,
+		method: method,
+		match:  escapePattern(r.prefix) + pattern,
+		count:  count,
+		prefix: r.prefix,
+		metric: m,
+	}
+
+	cc, ok := r.client.(*redis.ClusterClient)
+	if !ok {
+		it.nodes = []redis.Cmdable{r.client}
+		return it
+	}
+
+	// keys live on masters, replicas would return them again
+	start := time.Now()
+	lock := &sync.Mutex{}
+	it.err = cc.ForEachMaster(ctx, func(ctx context.Context, client *redis.Client) error {
+		lock.Lock()
+		defer lock.Unlock()
+		it.nodes = append(it.nodes, client)
+		return nil
+	})
+	if it.err != nil {
+		recordOperation(m, "redis", "scan", method, start, it.err)
+	}
+
+	return it
+}
+
+// DeleteByPattern
+// scans keys which match pattern and removes each page of batch size keys before the next SCAN
+func (r *redisCache) DeleteByPattern(ctx context.Context, method string, pattern string, batchSize int) (removed int64, err error) {
+	defer r.record("delete_pattern", method, time.Now(), &err)
+
+	if batchSize <= 0 {
+		batchSize = defaultDeleteBatch
+	}
+
+	// round trips of the scan are a part of this operation, they are not recorded on their own
+	it := r.scan(ctx, method, pattern, int64(batchSize), metric.NewNop())
+
+	batch := make([]string, 0, batchSize)
+	flush := func() error {
+		n, err := r.deleteInBatches(ctx, batch, batchSize)
+		removed += n
+		batch = batch[:0]
+		return err
+	}
+
+	for it.Next() {
+		batch = append(batch, it.stored)
+		if len(batch) < batchSize {
+			continue
+		}
+		if err := flush(); err != nil {
+			return removed, err
+		}
+	}
+	if err := flush(); err != nil {
+		return removed, err
+	}
+
+	if err := it.Err(); err != nil {
+		return removed, err
+	}
+
+	return removed, nil
+}
+
+// redisScanIterator
+// keeps cursor of SCAN on the current node and the page of keys which is returned by the last call
+type redisScanIterator struct {
+	ctx    context.Context
+	method string
+	match  string
+	count  int64
+	prefix string
+	metric metric.Metric
+
+	nodes   []redis.Cmdable
+	node    int
+	cursor  uint64
+	started bool
+	page    []string
+	stored  string
+	err     error
+}
+
+func (it *redisScanIterator) Next() bool {
+	for {
+		if len(it.page) > 0 {
+			it.stored, it.page = it.page[0], it.page[1:]
+			return true
+		}
+		if it.err != nil || it.node >= len(it.nodes) {
+			return false
+		}
+		// a zero cursor after the first call means the node is scanned completely
+		if it.started && it.cursor == 0 {
+			it.node++
+			it.started = false
+			continue
+		}
+
+		start := time.Now()
+		keys, cursor, err := it.nodes[it.node].Scan(it.ctx, it.cursor, it.match, it.count).Result()
+		recordOperation(it.metric, "redis", "scan", it.method, start, err)
+		if err != nil {
+			it.err = err
+			return false
+		}
+
+		it.started = true
+		it.cursor = cursor
+		it.page = keys
+	}
+}
+
+func (it *redisScanIterator) Key() string {
+	return strings.TrimPrefix(it.stored, it.prefix)
+}
+
+func (it *redisScanIterator) Err() error {
+	return it.err
+}
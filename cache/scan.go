@@ -0,0 +1,224 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// defaultDeleteBatch is number of keys which are removed by each command of DeleteByPattern by default
+const defaultDeleteBatch = 500
+
+// KeyIterator
+// walks keys which are found by Scan, e.g.
+//
+//	it := sc.Scan(ctx, "clear_vendor", cache.Key("vendor", "42")+":*", 0)
+//	for it.Next() {
+//		fmt.Println(it.Key())
+//	}
+//	if err := it.Err(); err != nil {
+//		return err
+//	}
+type KeyIterator interface {
+	// Next
+	// moves to the next key, it returns false when there are no more keys or an error happened
+	Next() bool
+	// Key
+	// returns the current key without namespace prefix of the cache
+	Key() string
+	// Err
+	// returns the error which stopped the iteration
+	Err() error
+}
+
+// ScanCache
+// is implemented by caches which are able to find keys by a glob style pattern like redis MATCH,
+// * matches any sequence, ? matches one character, [abc], [^abc] and [a-z] match a set of characters
+// and \ escapes the next character. patterns are matched against keys without namespace prefix
+type ScanCache interface {
+	Cache
+	// Scan
+	// to iterate keys which match pattern page by page, count is a hint of number of keys of each page,
+	// zero uses the default of the backend. a key may be returned more than once (e.g. redis rehashes during the scan)
+	// and keys which are added or removed during the scan may or may not be returned
+	Scan(ctx context.Context, method string, pattern string, count int64) KeyIterator
+	// DeleteByPattern
+	// to remove keys which match pattern with at most batchSize keys per command (500 when it is zero),
+	// so the backend is never blocked by a huge delete. it returns number of removed keys
+	DeleteByPattern(ctx context.Context, method string, pattern string, batchSize int) (int64, error)
+}
+
+// Scan
+// iterates keys of cache which match pattern, middlewares and wrappers which implement Unwrap are
+// looked through, so a decorated redis cache is scanned as well
+func Scan(ctx context.Context, cache Cache, method string, pattern string, count int64) (KeyIterator, error) {
+	sc, ok := unwrap(cache).(ScanCache)
+	if !ok {
+		return nil, fmt.Errorf("%T does not support scanning keys", cache)
+	}
+
+	return sc.Scan(ctx, method, pattern, count), nil
+}
+
+// DeleteByPattern
+// removes keys of cache which match pattern in batches, see ScanCache
+func DeleteByPattern(ctx context.Context, cache Cache, method string, pattern string, batchSize int) (int64, error) {
+	sc, ok := unwrap(cache).(ScanCache)
+	if !ok {
+		return 0, fmt.Errorf("%T does not support scanning keys", cache)
+	}
+
+	return sc.DeleteByPattern(ctx, method, pattern, batchSize)
+}
+
+// sliceIterator iterates keys which are already collected, e.g. by walking a map
+type sliceIterator struct {
+	keys []string
+	key  string
+}
+
+func (s *sliceIterator) Next() bool {
+	if len(s.keys) == 0 {
+		return false
+	}
+
+	s.key, s.keys = s.keys[0], s.keys[1:]
+	return true
+}
+
+func (s *sliceIterator) Key() string {
+	return s.key
+}
+
+func (s *sliceIterator) Err() error {
+	return nil
+}
+
+// concatIterator iterates keys of several iterators one after another, e.g. of all shards
+type concatIterator struct {
+	iterators []KeyIterator
+	err       error
+}
+
+func (c *concatIterator) Next() bool {
+	for len(c.iterators) > 0 {
+		if c.iterators[0].Next() {
+			return true
+		}
+		if err := c.iterators[0].Err(); err != nil {
+			c.err = err
+			return false
+		}
+		c.iterators = c.iterators[1:]
+	}
+
+	return false
+}
+
+func (c *concatIterator) Key() string {
+	if len(c.iterators) == 0 {
+		return ""
+	}
+
+	return c.iterators[0].Key()
+}
+
+func (c *concatIterator) Err() error {
+	return c.err
+}
+
+// matchPattern
+// reports whether key matches a glob style pattern with semantics of redis MATCH
+func matchPattern(pattern string, key string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(key); i++ {
+				if matchPattern(pattern[1:], key[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(key) == 0 {
+				return false
+			}
+			key = key[1:]
+			pattern = pattern[1:]
+		case '[':
+			if len(key) == 0 {
+				return false
+			}
+			matched, rest := matchClass(pattern[1:], key[0])
+			if !matched {
+				return false
+			}
+			key = key[1:]
+			pattern = rest
+		default:
+			if pattern[0] == '\\' && len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			if len(key) == 0 || pattern[0] != key[0] {
+				return false
+			}
+			key = key[1:]
+			pattern = pattern[1:]
+		}
+	}
+
+	return len(key) == 0
+}
+
+// matchClass
+// matches c against a character class which starts after '[', it returns the pattern after the class
+func matchClass(pattern string, c byte) (bool, string) {
+	negate := false
+	if len(pattern) > 0 && pattern[0] == '^' {
+		negate = true
+		pattern = pattern[1:]
+	}
+
+	matched := false
+	for len(pattern) > 0 && pattern[0] != ']' {
+		switch {
+		case pattern[0] == '\\' && len(pattern) > 1:
+			matched = matched || pattern[1] == c
+			pattern = pattern[2:]
+		case len(pattern) > 2 && pattern[1] == '-' && pattern[2] != ']':
+			lo, hi := pattern[0], pattern[2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			matched = matched || (c >= lo && c <= hi)
+			pattern = pattern[3:]
+		default:
+			matched = matched || pattern[0] == c
+			pattern = pattern[1:]
+		}
+	}
+	// like redis an unterminated class ends at the end of pattern
+	pattern = strings.TrimPrefix(pattern, "]")
+
+	return matched != negate, pattern
+}
+
+// escapePattern escapes glob characters of s, so it is matched literally, e.g. a namespace prefix
+func escapePattern(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+
+	return b.String()
+}
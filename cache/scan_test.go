@@ -0,0 +1,171 @@
+package cache
+
+import (
+	"context"
+	"github.com/Electronic-Catalog/microkit/metric/metrictest"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/require"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var (
+	_ ScanCache = (*redisCache)(nil)
+	_ ScanCache = (*memCache)(nil)
+	_ ScanCache = (*shardedMemCache)(nil)
+)
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		key     string
+		match   bool
+	}{
+		{"*", "", true},
+		{"*", "vendor:1:product:2", true},
+		{"vendor:1:*", "vendor:1:product:2", true},
+		{"vendor:1:*", "vendor:12:product:2", false},
+		{"vendor:?:*", "vendor:1:x", true},
+		{"vendor:?:*", "vendor:12:x", false},
+		{"*:product:*", "vendor:1:product:2", true},
+		{"vendor:[12]", "vendor:2", true},
+		{"vendor:[^12]", "vendor:2", false},
+		{"vendor:[a-c]", "vendor:b", true},
+		{"vendor:[a-c]", "vendor:d", false},
+		{`vendor:\*`, "vendor:*", true},
+		{`vendor:\*`, "vendor:1", false},
+		{"vendor", "vendor:1", false},
+		{"a*b*c", "aXXbYYc", true},
+		{"a*b*c", "aXXbYY", false},
+	}
+
+	for _, test := range tests {
+		require.Equal(t, test.match, matchPattern(test.pattern, test.key), "%q %q", test.pattern, test.key)
+	}
+
+	require.True(t, matchPattern(escapePattern("a*[b]?")+"*", "a*[b]?:1"))
+	require.False(t, matchPattern(escapePattern("a*")+"*", "ab:1"))
+}
+
+func TestScanAndDeleteByPattern(t *testing.T) {
+	srv := miniredis.RunT(t)
+	rd, err := NewRedisCache(WithAddresses(nil, srv.Addr()), WithKeyPrefix("catalog"))
+	require.NoError(t, err)
+	cluster, err := NewRedisCache(WithClusterAddresses(srv.Addr()))
+	require.NoError(t, err)
+	mem, err := NewInMemoryCache(time.Minute)
+	require.NoError(t, err)
+	sharded, err := NewInMemoryCache(time.Minute, WithShards(4))
+	require.NoError(t, err)
+
+	caches := map[string]Cache{
+		"redis":   rd,
+		"cluster": cluster,
+		"mem":     mem,
+		"sharded": sharded,
+	}
+
+	for name, c := range caches {
+		t.Run(name, func(t *testing.T) {
+			srv.FlushAll()
+			ctx := context.Background()
+			sc := c.(ScanCache)
+
+			for i := 0; i < 25; i++ {
+				require.NoError(t, sc.Set(ctx, "nop", Key("vendor", "7", "product", strconv.Itoa(i)), "v", time.Minute))
+			}
+			require.NoError(t, sc.Set(ctx, "nop", Key("vendor", "8", "product", "1"), "v", time.Minute))
+
+			var keys []string
+			it := sc.Scan(ctx, "nop", "vendor:8:*", 5)
+			for it.Next() {
+				keys = append(keys, it.Key())
+			}
+			require.NoError(t, it.Err())
+			require.Equal(t, []string{"vendor:8:product:1"}, keys)
+
+			seen := map[string]bool{}
+			it = sc.Scan(ctx, "nop", "vendor:7:*", 5)
+			for it.Next() {
+				seen[it.Key()] = true
+			}
+			require.NoError(t, it.Err())
+			require.Len(t, seen, 25)
+
+			// cursors of miniredis are offsets which are shifted by deletes, unlike redis, so the whole
+			// scan fits in one batch here
+			removed, err := sc.DeleteByPattern(ctx, "nop", "vendor:7:*", 100)
+			require.NoError(t, err)
+			require.Equal(t, int64(25), removed)
+
+			_, err = sc.GetKey(ctx, "nop", Key("vendor", "7", "product", "3"))
+			require.ErrorIs(t, err, NotFoundError)
+			val, err := sc.GetKey(ctx, "nop", Key("vendor", "8", "product", "1"))
+			require.NoError(t, err)
+			require.Equal(t, "v", val)
+
+			removed, err = sc.DeleteByPattern(ctx, "nop", "vendor:7:*", 0)
+			require.NoError(t, err)
+			require.Zero(t, removed)
+		})
+	}
+}
+
+func TestScanNamespace(t *testing.T) {
+	srv := miniredis.RunT(t)
+	ctx := context.Background()
+	catalog, err := NewRedisCache(WithAddresses(nil, srv.Addr()), WithKeyPrefix("catalog"))
+	require.NoError(t, err)
+	order, err := NewRedisCache(WithAddresses(nil, srv.Addr()), WithKeyPrefix("order"))
+	require.NoError(t, err)
+
+	require.NoError(t, catalog.Set(ctx, "nop", "product:1", "v", time.Minute))
+	require.NoError(t, order.Set(ctx, "nop", "product:1", "v", time.Minute))
+
+	// keys of other namespaces are neither returned nor removed
+	removed, err := DeleteByPattern(ctx, catalog, "nop", "*", 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), removed)
+	require.True(t, srv.Exists("order:product:1"))
+	require.False(t, srv.Exists("catalog:product:1"))
+}
+
+func TestScanThroughMiddlewares(t *testing.T) {
+	srv := miniredis.RunT(t)
+	ctx := context.Background()
+	m := metrictest.NewRecorder()
+	rd, err := NewRedisCache(WithAddresses(nil, srv.Addr()), WithMetricOption(m))
+	require.NoError(t, err)
+	c := Chain(rd, TimeoutMiddleware(time.Second))
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, c.Set(ctx, "nop", Key("vendor", "7", strconv.Itoa(i)), "v", time.Minute))
+	}
+
+	it, err := Scan(ctx, c, "scan_vendor", "vendor:7:*", 0)
+	require.NoError(t, err)
+	var keys []string
+	for it.Next() {
+		keys = append(keys, it.Key())
+	}
+	require.NoError(t, it.Err())
+	sort.Strings(keys)
+	require.Equal(t, []string{"vendor:7:0", "vendor:7:1", "vendor:7:2"}, keys)
+	require.Positive(t, m.Total("redis", "scan", "scan_vendor", "hit"))
+
+	removed, err := DeleteByPattern(ctx, c, "clear_vendor", "vendor:7:*", 10)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), removed)
+	// the scan of a delete is recorded as a part of it
+	require.Equal(t, 1, m.Total("redis", "delete_pattern", "clear_vendor", "hit"))
+
+	srv.SetError("server is down")
+	_, err = DeleteByPattern(ctx, c, "clear_vendor", "vendor:7:*", 10)
+	require.Error(t, err)
+	require.Equal(t, 1, m.Error("redis", "delete_pattern", "clear_vendor", "server"))
+
+	_, err = Scan(ctx, &plainCache{Cache: rd}, "nop", "*", 0)
+	require.Error(t, err)
+}
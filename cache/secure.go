@@ -0,0 +1,187 @@
+package cache
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/Electronic-Catalog/microkit/ciphered"
+	"github.com/Electronic-Catalog/microkit/metric"
+	"strings"
+	"time"
+)
+
+// secureEnvelopePrefix marks encrypted values, the digit is version of the format "enc1:<key id>:<ciphertext>"
+const secureEnvelopePrefix = "enc1:"
+
+// secureMinPayload is the size of nonce and tag of AES-GCM, shorter ciphertexts are corrupted
+const secureMinPayload = 12 + 16
+
+type secureCache struct {
+	Cache
+	keys        map[string][]byte
+	activeKeyID string
+	metric      metric.Metric
+}
+
+// NewSecureCache
+// wraps cache with transparent compression and encryption of values, Set compresses values with gzip
+// and encrypts them with AES-GCM by the active key, GetKey reverses both.
+// each value carries id of its key, so after a rotation values of the previous keys are readable
+// as long as those keys are still given by WithEncryptionKey.
+// counters (Incr, IncrBy and Decr) are not encrypted, don't read them by GetKey of a secure cache
+func NewSecureCache(cache Cache, options ...Option) (Cache, error) {
+	sc := secureCache{
+		Cache:  cache,
+		keys:   make(map[string][]byte),
+		metric: metric.NewNop(),
+	}
+
+	for _, op := range options {
+		err := op(&sc)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(sc.keys) == 0 {
+		return nil, errors.New("secure cache needs at least one key, use WithEncryptionKey option")
+	}
+	if sc.activeKeyID == "" {
+		if len(sc.keys) > 1 {
+			return nil, errors.New("secure cache with several keys needs WithActiveKeyID option")
+		}
+		for id := range sc.keys {
+			sc.activeKeyID = id
+		}
+	}
+	if _, ok := sc.keys[sc.activeKeyID]; !ok {
+		return nil, fmt.Errorf("active key id %q is not given by WithEncryptionKey", sc.activeKeyID)
+	}
+
+	return &sc, nil
+}
+
+func (s *secureCache) GetKey(ctx context.Context, method string, key string) (string, error) {
+	val, err := s.Cache.GetKey(ctx, method, key)
+	if err != nil {
+		return "", err
+	}
+
+	return s.open(method, key, val)
+}
+
+func (s *secureCache) Set(ctx context.Context, method string, key string, val string, expiration time.Duration) error {
+	sealed, err := s.seal(method, val)
+	if err != nil {
+		return err
+	}
+
+	return s.Cache.Set(ctx, method, key, sealed, expiration)
+}
+
+func (s *secureCache) SetNX(ctx context.Context, method string, key string, val string, expiration time.Duration) (bool, error) {
+	sealed, err := s.seal(method, val)
+	if err != nil {
+		return false, err
+	}
+
+	return s.Cache.SetNX(ctx, method, key, sealed, expiration)
+}
+
+func (s *secureCache) MGet(ctx context.Context, method string, keys ...string) (map[string]string, error) {
+	vals, err := MGet(ctx, s.Cache, method, keys...)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, val := range vals {
+		vals[key], err = s.open(method, key, val)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return vals, nil
+}
+
+func (s *secureCache) MSet(ctx context.Context, method string, items map[string]string, expiration time.Duration) error {
+	sealed := make(map[string]string, len(items))
+	for key, val := range items {
+		var err error
+		sealed[key], err = s.seal(method, val)
+		if err != nil {
+			return err
+		}
+	}
+
+	return MSet(ctx, s.Cache, method, sealed, expiration)
+}
+
+func (s *secureCache) MDel(ctx context.Context, method string, keys ...string) error {
+	return MDel(ctx, s.Cache, method, keys...)
+}
+
+// Unwrap
+// returns the wrapped cache, so helpers which need a specific backend (e.g. RedisClient) see through encryption,
+// values which are read through the returned cache are still encrypted
+func (s *secureCache) Unwrap() Cache {
+	return s.Cache
+}
+
+// record records an operation with the metric schema of backends, err points to the result of operation
+func (s *secureCache) record(op string, method string, start time.Time, err *error) {
+	recordOperation(s.metric, "secure", op, method, start, *err)
+}
+
+// seal compresses and encrypts val by the active key
+func (s *secureCache) seal(method string, val string) (sealed string, err error) {
+	defer s.record("seal", method, time.Now(), &err)
+
+	compressed, err := ciphered.Encode(val)
+	if err != nil {
+		return "", err
+	}
+
+	encrypted, err := ciphered.Encrypt(compressed, s.keys[s.activeKeyID])
+	if err != nil {
+		return "", err
+	}
+
+	return secureEnvelopePrefix + s.activeKeyID + ":" + encrypted, nil
+}
+
+// open decrypts and decompresses a value which is sealed by any of the known keys
+func (s *secureCache) open(method string, key string, val string) (plain string, err error) {
+	defer s.record("open", method, time.Now(), &err)
+
+	if !strings.HasPrefix(val, secureEnvelopePrefix) {
+		return "", &DecodeError{Key: key, Err: errors.New("value is not encrypted")}
+	}
+
+	keyID, encrypted, ok := strings.Cut(strings.TrimPrefix(val, secureEnvelopePrefix), ":")
+	if !ok {
+		return "", &DecodeError{Key: key, Err: errors.New("malformed encrypted value")}
+	}
+	secret, ok := s.keys[keyID]
+	if !ok {
+		return "", &DecodeError{Key: key, Err: fmt.Errorf("unknown encryption key id %q", keyID)}
+	}
+
+	payload, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil || len(payload) < secureMinPayload {
+		return "", &DecodeError{Key: key, Err: errors.New("truncated or malformed ciphertext")}
+	}
+
+	compressed, err := ciphered.Decrypt(encrypted, secret)
+	if err != nil {
+		return "", &DecodeError{Key: key, Err: err}
+	}
+
+	plain, err = ciphered.Decode(compressed)
+	if err != nil {
+		return "", &DecodeError{Key: key, Err: err}
+	}
+
+	return plain, nil
+}
@@ -0,0 +1,102 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/Electronic-Catalog/microkit/metric/metrictest"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSecureCache(t *testing.T) {
+	srv := miniredis.RunT(t)
+	rd, err := NewRedisCache(WithAddresses(nil, srv.Addr()))
+	require.NoError(t, err)
+	ctx := context.Background()
+	oldKey := bytes.Repeat([]byte("o"), 32)
+	newKey := bytes.Repeat([]byte("n"), 32)
+
+	mt := metrictest.NewRecorder()
+	before, err := NewSecureCache(rd, WithEncryptionKey("2023", oldKey), WithMetricOption(mt))
+	require.NoError(t, err)
+
+	pii := `{"name":"Jane","phone":"+989121234567"}`
+	require.NoError(t, before.Set(ctx, "nop", "user:1", pii, time.Minute))
+
+	stored, err := srv.Get("user:1")
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(stored, "enc1:2023:"))
+	require.NotContains(t, stored, "Jane")
+
+	val, err := before.GetKey(ctx, "nop", "user:1")
+	require.NoError(t, err)
+	require.Equal(t, pii, val)
+
+	// values encrypted before a rotation stay readable
+	after, err := NewSecureCache(rd, WithEncryptionKey("2023", oldKey), WithEncryptionKey("2024", newKey), WithActiveKeyID("2024"))
+	require.NoError(t, err)
+	require.NoError(t, after.Set(ctx, "nop", "user:2", "second", time.Minute))
+	stored, err = srv.Get("user:2")
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(stored, "enc1:2024:"))
+
+	got, err := MGet(ctx, after, "nop", "user:1", "user:2", "user:3")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"user:1": pii, "user:2": "second"}, got)
+
+	// a cache which doesn't know the key id can not read the value
+	_, err = before.GetKey(ctx, "nop", "user:2")
+	var decodeErr *DecodeError
+	require.True(t, errors.As(err, &decodeErr))
+	require.Equal(t, "user:2", decodeErr.Key)
+	require.Equal(t, 1, mt.Total("secure", "seal", "nop", OutcomeHit))
+	require.Equal(t, 1, mt.Total("secure", "open", "nop", OutcomeHit))
+	require.Equal(t, 1, mt.Error("secure", "open", "nop", "decode"))
+
+	require.NoError(t, srv.Set("user:3", "plain"))
+	_, err = after.GetKey(ctx, "nop", "user:3")
+	require.True(t, errors.As(err, &decodeErr))
+
+	_, err = after.GetKey(ctx, "nop", "user:4")
+	require.ErrorIs(t, err, NotFoundError)
+
+	// truncated and garbage ciphertexts are decode errors
+	for _, stored := range []string{"enc1:2024:", "enc1:2024:AAAAAAAAAAAA", "enc1:2024:" + strings.Repeat("A", 40), "enc1:2024:not base64!"} {
+		require.NoError(t, srv.Set("user:5", stored))
+		_, err = after.GetKey(ctx, "nop", "user:5")
+		require.True(t, errors.As(err, &decodeErr), stored)
+		_, err = MGet(ctx, after, "nop", "user:5")
+		require.True(t, errors.As(err, &decodeErr), stored)
+	}
+}
+
+func TestSecureCacheOptions(t *testing.T) {
+	mem, err := NewInMemoryCache(time.Second)
+	require.NoError(t, err)
+	defer mem.Close()
+	key := bytes.Repeat([]byte("k"), 32)
+
+	_, err = NewSecureCache(mem)
+	require.Error(t, err)
+	_, err = NewSecureCache(mem, WithEncryptionKey("1", key[:16]))
+	require.Error(t, err)
+	_, err = NewSecureCache(mem, WithEncryptionKey("a:b", key))
+	require.Error(t, err)
+	_, err = NewSecureCache(mem, WithEncryptionKey("1", key), WithEncryptionKey("2", key))
+	require.Error(t, err)
+	_, err = NewSecureCache(mem, WithEncryptionKey("1", key), WithActiveKeyID("2"))
+	require.Error(t, err)
+
+	sc, err := NewSecureCache(mem, WithEncryptionKey("1", key))
+	require.NoError(t, err)
+	stored, err := sc.SetNX(context.Background(), "nop", "owner", "replica-1", time.Minute)
+	require.NoError(t, err)
+	require.True(t, stored)
+	val, err := sc.GetKey(context.Background(), "nop", "owner")
+	require.NoError(t, err)
+	require.Equal(t, "replica-1", val)
+}
@@ -0,0 +1,254 @@
+package cache
+
+import (
+	"context"
+	"hash/maphash"
+	"io"
+	"sync"
+	"time"
+)
+
+// shardedMemCache
+// spreads keys over independent memCache shards, each of them has its own lock,
+// eviction policy and expiry sweep
+type shardedMemCache struct {
+	shards []*memCache
+	seed   maphash.Seed
+
+	snapshot  *snapshotFile
+	done      chan struct{}
+	closeOnce sync.Once
+	locker    *memLocker
+}
+
+// newShardedMemCache
+// creates shards with configuration of template, limits are divided between shards
+func newShardedMemCache(template *memCache) (*shardedMemCache, error) {
+	sc := shardedMemCache{
+		shards:   make([]*memCache, template.shardCount),
+		seed:     maphash.MakeSeed(),
+		snapshot: template.snapshot,
+		done:     make(chan struct{}),
+		locker:   template.locker,
+	}
+
+	for i := range sc.shards {
+		shard := newMemCache(template.evictionInterval)
+		shard.metric = template.metric
+		shard.policyName = template.policyName
+		shard.maxEntries = divideLimit(template.maxEntries, template.shardCount)
+		shard.maxBytes = int64(divideLimit(int(template.maxBytes), template.shardCount))
+
+		err := shard.start()
+		if err != nil {
+			_ = sc.Close()
+			return nil, err
+		}
+		sc.shards[i] = shard
+	}
+
+	return &sc, nil
+}
+
+// divideLimit rounds up, so a small limit never becomes zero (unlimited) in a shard
+func divideLimit(limit int, shards int) int {
+	if limit <= 0 {
+		return 0
+	}
+
+	return (limit + shards - 1) / shards
+}
+
+func (s *shardedMemCache) shard(key string) *memCache {
+	return s.shards[maphash.String(s.seed, key)%uint64(len(s.shards))]
+}
+
+// groupByShard splits keys of a batch operation by their shard
+func (s *shardedMemCache) groupByShard(keys []string) map[*memCache][]string {
+	groups := make(map[*memCache][]string)
+	for _, key := range keys {
+		shard := s.shard(key)
+		groups[shard] = append(groups[shard], key)
+	}
+
+	return groups
+}
+
+func (s *shardedMemCache) Ping(ctx context.Context) error {
+	// NOP
+	return nil
+}
+
+func (s *shardedMemCache) GetKey(ctx context.Context, method string, key string) (string, error) {
+	return s.shard(key).GetKey(ctx, method, key)
+}
+
+func (s *shardedMemCache) Set(ctx context.Context, method string, key string, val string, expiration time.Duration) error {
+	return s.shard(key).Set(ctx, method, key, val, expiration)
+}
+
+func (s *shardedMemCache) RemoveKey(ctx context.Context, method string, key string) error {
+	return s.shard(key).RemoveKey(ctx, method, key)
+}
+
+func (s *shardedMemCache) Incr(ctx context.Context, method string, key string) (int64, error) {
+	return s.shard(key).Incr(ctx, method, key)
+}
+
+func (s *shardedMemCache) IncrBy(ctx context.Context, method string, key string, delta int64) (int64, error) {
+	return s.shard(key).IncrBy(ctx, method, key, delta)
+}
+
+func (s *shardedMemCache) Decr(ctx context.Context, method string, key string) (int64, error) {
+	return s.shard(key).Decr(ctx, method, key)
+}
+
+func (s *shardedMemCache) SetNX(ctx context.Context, method string, key string, val string, expiration time.Duration) (bool, error) {
+	return s.shard(key).SetNX(ctx, method, key, val, expiration)
+}
+
+func (s *shardedMemCache) TTL(ctx context.Context, method string, key string) (time.Duration, error) {
+	return s.shard(key).TTL(ctx, method, key)
+}
+
+func (s *shardedMemCache) Expire(ctx context.Context, method string, key string, expiration time.Duration) error {
+	return s.shard(key).Expire(ctx, method, key, expiration)
+}
+
+// Close
+// stops expiry sweep of all shards and writes the last snapshot of all of them when WithSnapshotFile is given
+func (s *shardedMemCache) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		for _, shard := range s.shards {
+			if shard != nil {
+				_ = shard.Close()
+			}
+		}
+		if s.snapshot != nil {
+			err = s.snapshot.writeLast(s)
+		}
+	})
+
+	return err
+}
+
+// Snapshot
+// writes items of all shards as one snapshot, so it is restorable with another number of shards
+func (s *shardedMemCache) Snapshot(w io.Writer) error {
+	now := time.Now()
+	var entries []snapshotEntry
+	for _, shard := range s.shards {
+		shard.lock.RLock()
+		entries = append(entries, shard.snapshotEntries(now)...)
+		shard.lock.RUnlock()
+	}
+
+	return writeSnapshot(w, entries, now)
+}
+
+func (s *shardedMemCache) Restore(r io.Reader) error {
+	entries, createdAt, err := readSnapshot(r)
+	if err != nil {
+		return err
+	}
+
+	groups := make(map[*memCache][]snapshotEntry)
+	for _, e := range entries {
+		shard := s.shard(e.key)
+		groups[shard] = append(groups[shard], e)
+	}
+
+	now := time.Now()
+	for shard, group := range groups {
+		shard.restoreEntries(group, createdAt, now)
+	}
+
+	return nil
+}
+
+func (s *shardedMemCache) MGet(ctx context.Context, method string, keys ...string) (map[string]string, error) {
+	result := make(map[string]string, len(keys))
+	for shard, group := range s.groupByShard(keys) {
+		vals, err := shard.MGet(ctx, method, group...)
+		if err != nil {
+			return nil, err
+		}
+		for key, val := range vals {
+			result[key] = val
+		}
+	}
+
+	return result, nil
+}
+
+func (s *shardedMemCache) MSet(ctx context.Context, method string, items map[string]string, expiration time.Duration) error {
+	groups := make(map[*memCache]map[string]string)
+	for key, val := range items {
+		shard := s.shard(key)
+		if groups[shard] == nil {
+			groups[shard] = make(map[string]string)
+		}
+		groups[shard][key] = val
+	}
+
+	for shard, group := range groups {
+		err := shard.MSet(ctx, method, group, expiration)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *shardedMemCache) MDel(ctx context.Context, method string, keys ...string) error {
+	for shard, group := range s.groupByShard(keys) {
+		err := shard.MDel(ctx, method, group...)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *shardedMemCache) SetWithTags(ctx context.Context, method string, key string, val string, expiration time.Duration, tags ...string) error {
+	return s.shard(key).SetWithTags(ctx, method, key, val, expiration, tags...)
+}
+
+// InvalidateTags
+// each shard indexes tags of its own keys, so all shards are asked
+func (s *shardedMemCache) InvalidateTags(ctx context.Context, method string, tags ...string) error {
+	for _, shard := range s.shards {
+		err := shard.InvalidateTags(ctx, method, tags...)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *shardedMemCache) Scan(ctx context.Context, method string, pattern string, count int64) KeyIterator {
+	iterators := make([]KeyIterator, len(s.shards))
+	for i, shard := range s.shards {
+		iterators[i] = shard.Scan(ctx, method, pattern, count)
+	}
+
+	return &concatIterator{iterators: iterators}
+}
+
+func (s *shardedMemCache) DeleteByPattern(ctx context.Context, method string, pattern string, batchSize int) (int64, error) {
+	var removed int64
+	for _, shard := range s.shards {
+		n, err := shard.DeleteByPattern(ctx, method, pattern, batchSize)
+		removed += n
+		if err != nil {
+			return removed, err
+		}
+	}
+
+	return removed, nil
+}
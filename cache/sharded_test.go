@@ -0,0 +1,90 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestShardedMemCache(t *testing.T) {
+	ctx := context.Background()
+	mem, err := NewInMemoryCache(time.Millisecond*20, WithShards(8), WithMaxEntries(80))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	sc, ok := mem.(*shardedMemCache)
+	require.True(t, ok)
+	require.Len(t, sc.shards, 8)
+	for _, shard := range sc.shards {
+		require.Equal(t, 10, shard.maxEntries)
+	}
+
+	require.NoError(t, mem.Set(ctx, "nop", "product:1", "v1", time.Minute))
+	require.NoError(t, mem.Set(ctx, "nop", "product:2", "v2", time.Millisecond*10))
+
+	val, err := mem.GetKey(ctx, "nop", "product:1")
+	require.NoError(t, err)
+	require.Equal(t, "v1", val)
+
+	// each shard sweeps its own expired keys
+	require.Eventually(t, func() bool {
+		shard := sc.shard("product:2")
+		shard.lock.RLock()
+		defer shard.lock.RUnlock()
+		_, ok := shard.store["product:2"]
+		return !ok
+	}, time.Second, time.Millisecond*5)
+
+	require.NoError(t, mem.RemoveKey(ctx, "nop", "product:1"))
+	_, err = mem.GetKey(ctx, "nop", "product:1")
+	require.ErrorIs(t, err, NotFoundError)
+
+	_, err = NewInMemoryCache(time.Second, WithShards(0))
+	require.Error(t, err)
+}
+
+func benchmarkMemCache(b *testing.B, options ...Option) {
+	ctx := context.Background()
+	mem, err := NewInMemoryCache(time.Second, options...)
+	require.NoError(b, err)
+	defer mem.Close()
+
+	keys := make([]string, 10000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("product:%d", i)
+		require.NoError(b, mem.Set(ctx, "bench", keys[i], "value", time.Minute))
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		rnd := rand.New(rand.NewSource(rand.Int63()))
+		for pb.Next() {
+			key := keys[rnd.Intn(len(keys))]
+			// 90% reads and 10% writes
+			if rnd.Intn(10) == 0 {
+				_ = mem.Set(ctx, "bench", key, "value", time.Minute)
+			} else {
+				_, _ = mem.GetKey(ctx, "bench", key)
+			}
+		}
+	})
+}
+
+func BenchmarkMemCache(b *testing.B) {
+	benchmarkMemCache(b)
+}
+
+func BenchmarkShardedMemCache(b *testing.B) {
+	benchmarkMemCache(b, WithShards(32))
+}
+
+func BenchmarkBoundedMemCache(b *testing.B) {
+	benchmarkMemCache(b, WithMaxEntries(20000))
+}
+
+func BenchmarkBoundedShardedMemCache(b *testing.B) {
+	benchmarkMemCache(b, WithMaxEntries(20000), WithShards(32))
+}
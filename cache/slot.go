@@ -0,0 +1,48 @@
+package cache
+
+import "strings"
+
+// clusterSlots is the number of hash slots in a redis cluster
+const clusterSlots = 16384
+
+// hashSlot
+// calculates the redis cluster slot of key, when key contains a hash tag like "{user:1}:profile"
+// only the tag is hashed, so keys sharing a tag live in the same slot
+func hashSlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+
+	return int(crc16(key) % clusterSlots)
+}
+
+// groupBySlot
+// splits keys into groups which are accepted by multi-key commands in cluster mode
+func groupBySlot(keys []string) map[int][]string {
+	groups := make(map[int][]string)
+	for _, key := range keys {
+		slot := hashSlot(key)
+		groups[slot] = append(groups[slot], key)
+	}
+
+	return groups
+}
+
+// crc16 is the CRC16-CCITT (XMODEM) checksum which redis cluster uses for key distribution
+func crc16(key string) uint16 {
+	var crc uint16
+	for i := 0; i < len(key); i++ {
+		crc ^= uint16(key[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+
+	return crc
+}
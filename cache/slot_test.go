@@ -0,0 +1,20 @@
+package cache
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestHashSlot(t *testing.T) {
+	// reference values from CLUSTER KEYSLOT
+	require.Equal(t, 12182, hashSlot("foo"))
+	require.Equal(t, 5061, hashSlot("bar"))
+	require.Equal(t, hashSlot("user:1"), hashSlot("{user:1}:profile"))
+	require.Equal(t, hashSlot("{user:1}:orders"), hashSlot("{user:1}:profile"))
+	// empty hash tag is not a tag
+	require.Equal(t, int(crc16("{}foo")%clusterSlots), hashSlot("{}foo"))
+
+	groups := groupBySlot([]string{"{a}1", "{a}2", "{b}1"})
+	require.Len(t, groups, 2)
+	require.ElementsMatch(t, []string{"{a}1", "{a}2"}, groups[hashSlot("a")])
+}
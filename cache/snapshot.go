@@ -0,0 +1,356 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/Electronic-Catalog/microkit/metric"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// snapshot format, integers are big-endian or varints:
+//
+//	magic "MKCACHE" | version uint16 | created at int64 unix nano | entry count uvarint
+//	entries: key | value | remaining ttl varint nanoseconds, zero means never | tag count uvarint | tags
+//	crc32 (IEEE) of everything above uint32
+//
+// strings are written as uvarint length followed by their bytes
+const (
+	snapshotMagic   = "MKCACHE"
+	snapshotVersion = 1
+	// snapshotMaxString bounds allocations while a corrupted snapshot is read
+	snapshotMaxString = 1 << 30
+)
+
+// SnapshotCache
+// is implemented by in-memory caches which are able to save their items and load them back,
+// e.g. to keep the cache warm across restarts of a service
+type SnapshotCache interface {
+	Cache
+	// Snapshot
+	// to write all items with their remaining ttl and tags to w, expired items are left out
+	Snapshot(w io.Writer) error
+	// Restore
+	// to load items of a snapshot which is written by Snapshot, items which expired since then are skipped,
+	// nothing is loaded when the snapshot is corrupted
+	Restore(r io.Reader) error
+}
+
+type snapshotEntry struct {
+	key   string
+	value string
+	ttl   time.Duration
+	tags  []string
+}
+
+// snapshotEntries copies items which are not expired at now, the caller must hold the read lock
+func (m *memCache) snapshotEntries(now time.Time) []snapshotEntry {
+	entries := make([]snapshotEntry, 0, len(m.store))
+	for key, it := range m.store {
+		if it.expired(now) {
+			continue
+		}
+
+		e := snapshotEntry{key: key, value: it.value}
+		if !it.expiration.IsZero() {
+			e.ttl = it.expiration.Sub(now)
+		}
+		for tag := range m.keyTags[key] {
+			e.tags = append(e.tags, tag)
+		}
+		entries = append(entries, e)
+	}
+
+	return entries
+}
+
+// restoreEntries stores entries of a snapshot which is created at createdAt
+func (m *memCache) restoreEntries(entries []snapshotEntry, createdAt time.Time, now time.Time) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	elapsed := now.Sub(createdAt)
+	for _, e := range entries {
+		var expiration time.Time
+		if e.ttl > 0 {
+			remaining := e.ttl - elapsed
+			if remaining <= 0 {
+				continue
+			}
+			expiration = now.Add(remaining)
+		}
+
+		m.setItem(e.key, e.value, expiration)
+		for _, tag := range e.tags {
+			m.tag(e.key, tag)
+		}
+	}
+	m.enforceLimits("snapshot")
+}
+
+func (m *memCache) Snapshot(w io.Writer) error {
+	now := time.Now()
+	m.lock.RLock()
+	entries := m.snapshotEntries(now)
+	m.lock.RUnlock()
+
+	return writeSnapshot(w, entries, now)
+}
+
+func (m *memCache) Restore(r io.Reader) error {
+	entries, createdAt, err := readSnapshot(r)
+	if err != nil {
+		return err
+	}
+
+	m.restoreEntries(entries, createdAt, time.Now())
+
+	return nil
+}
+
+func writeSnapshot(w io.Writer, entries []snapshotEntry, createdAt time.Time) error {
+	crc := crc32.NewIEEE()
+	bw := bufio.NewWriter(io.MultiWriter(w, crc))
+
+	var buf [binary.MaxVarintLen64]byte
+	writeUvarint := func(v uint64) {
+		n := binary.PutUvarint(buf[:], v)
+		_, _ = bw.Write(buf[:n])
+	}
+	writeString := func(s string) {
+		writeUvarint(uint64(len(s)))
+		_, _ = bw.WriteString(s)
+	}
+
+	_, _ = bw.WriteString(snapshotMagic)
+	_ = binary.Write(bw, binary.BigEndian, uint16(snapshotVersion))
+	_ = binary.Write(bw, binary.BigEndian, createdAt.UnixNano())
+	writeUvarint(uint64(len(entries)))
+	for _, e := range entries {
+		writeString(e.key)
+		writeString(e.value)
+		n := binary.PutVarint(buf[:], int64(e.ttl))
+		_, _ = bw.Write(buf[:n])
+		writeUvarint(uint64(len(e.tags)))
+		for _, tag := range e.tags {
+			writeString(tag)
+		}
+	}
+
+	// errors of buffered writes are kept by the writer and returned by Flush
+	err := bw.Flush()
+	if err != nil {
+		return err
+	}
+
+	return binary.Write(w, binary.BigEndian, crc.Sum32())
+}
+
+// snapshotReader reads bytes through the checksum
+type snapshotReader struct {
+	r   io.Reader
+	one [1]byte
+}
+
+func (s *snapshotReader) Read(p []byte) (int, error) {
+	return s.r.Read(p)
+}
+
+func (s *snapshotReader) ReadByte() (byte, error) {
+	_, err := io.ReadFull(s.r, s.one[:])
+	return s.one[0], err
+}
+
+func (s *snapshotReader) readString() (string, error) {
+	n, err := binary.ReadUvarint(s)
+	if err != nil {
+		return "", err
+	}
+	if n > snapshotMaxString {
+		return "", fmt.Errorf("string of %d bytes is too long", n)
+	}
+
+	b := make([]byte, n)
+	_, err = io.ReadFull(s, b)
+	return string(b), err
+}
+
+func readSnapshot(r io.Reader) ([]snapshotEntry, time.Time, error) {
+	entries, createdAt, err := decodeSnapshot(r)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("%w: %v", InvalidSnapshotError, err)
+	}
+
+	return entries, createdAt, nil
+}
+
+func decodeSnapshot(r io.Reader) ([]snapshotEntry, time.Time, error) {
+	br := bufio.NewReader(r)
+	crc := crc32.NewIEEE()
+	sr := &snapshotReader{r: io.TeeReader(br, crc)}
+
+	magic := make([]byte, len(snapshotMagic))
+	_, err := io.ReadFull(sr, magic)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if string(magic) != snapshotMagic {
+		return nil, time.Time{}, errors.New("unknown format")
+	}
+
+	var version uint16
+	err = binary.Read(sr, binary.BigEndian, &version)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if version != snapshotVersion {
+		return nil, time.Time{}, fmt.Errorf("unsupported version %d", version)
+	}
+
+	var createdAt int64
+	err = binary.Read(sr, binary.BigEndian, &createdAt)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	count, err := binary.ReadUvarint(sr)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	var entries []snapshotEntry
+	for i := uint64(0); i < count; i++ {
+		var e snapshotEntry
+		if e.key, err = sr.readString(); err != nil {
+			return nil, time.Time{}, err
+		}
+		if e.value, err = sr.readString(); err != nil {
+			return nil, time.Time{}, err
+		}
+		ttl, err := binary.ReadVarint(sr)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		e.ttl = time.Duration(ttl)
+
+		tags, err := binary.ReadUvarint(sr)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		for j := uint64(0); j < tags; j++ {
+			tag, err := sr.readString()
+			if err != nil {
+				return nil, time.Time{}, err
+			}
+			e.tags = append(e.tags, tag)
+		}
+		entries = append(entries, e)
+	}
+
+	// the checksum itself is read around the tee
+	var sum uint32
+	err = binary.Read(br, binary.BigEndian, &sum)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if sum != crc.Sum32() {
+		return nil, time.Time{}, errors.New("checksum mismatch")
+	}
+
+	return entries, time.Unix(0, createdAt), nil
+}
+
+// snapshotFile
+// keeps snapshots of a cache in a file, it is written periodically and on Close
+type snapshotFile struct {
+	path     string
+	interval time.Duration
+	// running tracks the periodic writer, the last snapshot is written after it returns
+	// so an older periodic snapshot is never renamed over the last one
+	running sync.WaitGroup
+}
+
+// restore loads the snapshot file into cache, a missing file means there is nothing to restore
+func (f *snapshotFile) restore(c SnapshotCache) error {
+	file, err := os.Open(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return c.Restore(file)
+}
+
+// write replaces the snapshot file atomically, so a crash while writing leaves the previous snapshot
+func (f *snapshotFile) write(c SnapshotCache) error {
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = c.Snapshot(tmp)
+	if err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), f.path)
+}
+
+// startSnapshots
+// restores the snapshot file into c and writes the file in each interval until done is closed
+func startSnapshots(c SnapshotCache, f *snapshotFile, m metric.Metric, done chan struct{}) {
+	err := f.restore(c)
+	if err != nil {
+		// a corrupted snapshot must not keep the service from starting, it just starts cold
+		m.IncrementError("mem", "snapshot", "restore", errorKind(err))
+	}
+
+	if f.interval > 0 {
+		f.running.Add(1)
+		go func() {
+			defer f.running.Done()
+			f.process(c, func(err error) {
+				m.IncrementError("mem", "snapshot", "write", errorKind(err))
+			}, done)
+		}()
+	}
+}
+
+// writeLast
+// waits for the periodic writer which is stopped by closing done and writes the last snapshot of c
+func (f *snapshotFile) writeLast(c SnapshotCache) error {
+	f.running.Wait()
+	return f.write(c)
+}
+
+// process writes snapshots of cache in each interval until done is closed
+func (f *snapshotFile) process(c SnapshotCache, onError func(err error), done chan struct{}) {
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			err := f.write(c)
+			if err != nil {
+				onError(err)
+			}
+		}
+	}
+}
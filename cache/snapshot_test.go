@@ -0,0 +1,209 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"github.com/Electronic-Catalog/microkit/metric/metrictest"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var (
+	_ SnapshotCache = (*memCache)(nil)
+	_ SnapshotCache = (*shardedMemCache)(nil)
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	src := newMemForTest(t)
+	require.NoError(t, src.Set(ctx, "nop", "product:1", "v1", 0))
+	require.NoError(t, src.Set(ctx, "nop", "product:2", "v2", time.Minute))
+	require.NoError(t, src.(TaggedCache).SetWithTags(ctx, "nop", "product:3", "v3", time.Minute, "brand:1"))
+
+	var buf bytes.Buffer
+	require.NoError(t, src.(SnapshotCache).Snapshot(&buf))
+
+	dst := newMemForTest(t)
+	require.NoError(t, dst.(SnapshotCache).Restore(&buf))
+
+	for key, want := range map[string]string{"product:1": "v1", "product:2": "v2", "product:3": "v3"} {
+		val, err := dst.GetKey(ctx, "nop", key)
+		require.NoError(t, err)
+		require.Equal(t, want, val)
+	}
+
+	ttl, err := dst.TTL(ctx, "nop", "product:1")
+	require.NoError(t, err)
+	require.Equal(t, NoExpiration, ttl)
+
+	ttl, err = dst.TTL(ctx, "nop", "product:2")
+	require.NoError(t, err)
+	require.Greater(t, ttl, time.Second*50)
+	require.LessOrEqual(t, ttl, time.Minute)
+
+	// tags survive the snapshot
+	require.NoError(t, dst.(TaggedCache).InvalidateTags(ctx, "nop", "brand:1"))
+	_, err = dst.GetKey(ctx, "nop", "product:3")
+	require.ErrorIs(t, err, NotFoundError)
+}
+
+func TestSnapshotSkipsExpired(t *testing.T) {
+	ctx := context.Background()
+	src := newMemForTest(t)
+	require.NoError(t, src.Set(ctx, "nop", "short", "v1", time.Millisecond*30))
+	require.NoError(t, src.Set(ctx, "nop", "long", "v2", time.Minute))
+
+	var buf bytes.Buffer
+	require.NoError(t, src.(SnapshotCache).Snapshot(&buf))
+
+	// the short item expires between snapshot and restore
+	time.Sleep(time.Millisecond * 50)
+
+	dst := newMemForTest(t)
+	require.NoError(t, dst.(SnapshotCache).Restore(&buf))
+
+	_, err := dst.GetKey(ctx, "nop", "short")
+	require.ErrorIs(t, err, NotFoundError)
+	val, err := dst.GetKey(ctx, "nop", "long")
+	require.NoError(t, err)
+	require.Equal(t, "v2", val)
+}
+
+func TestSnapshotInvalid(t *testing.T) {
+	ctx := context.Background()
+	src := newMemForTest(t)
+	require.NoError(t, src.Set(ctx, "nop", "product:1", "v1", time.Minute))
+
+	var buf bytes.Buffer
+	require.NoError(t, src.(SnapshotCache).Snapshot(&buf))
+	valid := buf.Bytes()
+
+	corrupt := func(i int) []byte {
+		b := bytes.Clone(valid)
+		b[i] ^= 0xff
+		return b
+	}
+
+	for name, data := range map[string][]byte{
+		"checksum":  corrupt(len(valid) - 8),
+		"magic":     corrupt(0),
+		"version":   corrupt(len(snapshotMagic) + 1),
+		"truncated": valid[:len(valid)-2],
+		"empty":     nil,
+	} {
+		t.Run(name, func(t *testing.T) {
+			dst := newMemForTest(t)
+			err := dst.(SnapshotCache).Restore(bytes.NewReader(data))
+			require.ErrorIs(t, err, InvalidSnapshotError)
+
+			// nothing is loaded from a corrupted snapshot
+			_, err = dst.GetKey(ctx, "nop", "product:1")
+			require.ErrorIs(t, err, NotFoundError)
+		})
+	}
+}
+
+func TestSnapshotFile(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+
+	// a missing file is a cold start
+	mem, err := NewInMemoryCache(time.Minute, WithSnapshotFile(path, 0))
+	require.NoError(t, err)
+	require.NoError(t, mem.Set(ctx, "nop", "product:1", "v1", time.Minute))
+	require.NoError(t, mem.Close())
+
+	mem, err = NewInMemoryCache(time.Minute, WithSnapshotFile(path, 0))
+	require.NoError(t, err)
+	val, err := mem.GetKey(ctx, "nop", "product:1")
+	require.NoError(t, err)
+	require.Equal(t, "v1", val)
+	require.NoError(t, mem.Close())
+
+	// a corrupted file doesn't keep the cache from starting
+	require.NoError(t, os.WriteFile(path, []byte("garbage"), 0o600))
+	m := metrictest.NewRecorder()
+	mem, err = NewInMemoryCache(time.Minute, WithSnapshotFile(path, 0), WithMetricOption(m))
+	require.NoError(t, err)
+	defer mem.Close()
+	_, err = mem.GetKey(ctx, "nop", "product:1")
+	require.ErrorIs(t, err, NotFoundError)
+	require.Equal(t, 1, m.Error("mem", "snapshot", "restore", "error"))
+}
+
+func TestSnapshotFilePeriodic(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+
+	mem, err := NewInMemoryCache(time.Minute, WithSnapshotFile(path, time.Millisecond*20))
+	require.NoError(t, err)
+	defer mem.Close()
+	require.NoError(t, mem.Set(ctx, "nop", "product:1", "v1", time.Minute))
+
+	require.Eventually(t, func() bool {
+		f, err := os.Open(path)
+		if err != nil {
+			return false
+		}
+		defer f.Close()
+		dst := newMemForTest(t)
+		if dst.(SnapshotCache).Restore(f) != nil {
+			return false
+		}
+		_, err = dst.GetKey(ctx, "nop", "product:1")
+		return err == nil
+	}, time.Second, time.Millisecond*10)
+}
+
+func TestSnapshotCloseAfterPeriodic(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+
+	// a periodic write which is running on Close must not replace the last snapshot
+	for i := 0; i < 20; i++ {
+		mem, err := NewInMemoryCache(time.Minute, WithSnapshotFile(path, time.Millisecond))
+		require.NoError(t, err)
+		time.Sleep(time.Millisecond * 2)
+		require.NoError(t, mem.Set(ctx, "nop", "round", strconv.Itoa(i), time.Minute))
+		require.NoError(t, mem.Close())
+
+		restored, err := NewInMemoryCache(time.Minute, WithSnapshotFile(path, 0))
+		require.NoError(t, err)
+		val, err := restored.GetKey(ctx, "nop", "round")
+		require.NoError(t, err)
+		require.Equal(t, strconv.Itoa(i), val)
+		// closing writes the same snapshot again
+		require.NoError(t, restored.Close())
+	}
+}
+
+func TestSnapshotSharded(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+
+	mem, err := NewInMemoryCache(time.Minute, WithShards(4), WithSnapshotFile(path, 0))
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		require.NoError(t, mem.Set(ctx, "nop", Key("product", strconv.Itoa(i)), "v", time.Minute))
+	}
+	require.NoError(t, mem.Close())
+
+	// a snapshot of shards is restorable with another number of shards
+	restored, err := NewInMemoryCache(time.Minute, WithShards(8), WithSnapshotFile(path, 0))
+	require.NoError(t, err)
+	defer restored.Close()
+	for i := 0; i < 20; i++ {
+		_, err := restored.GetKey(ctx, "nop", Key("product", strconv.Itoa(i)))
+		require.NoError(t, err)
+	}
+
+	plain, err := NewInMemoryCache(time.Minute, WithSnapshotFile(path, 0))
+	require.NoError(t, err)
+	defer plain.Close()
+	_, err = plain.GetKey(ctx, "nop", Key("product", "7"))
+	require.NoError(t, err)
+}
@@ -0,0 +1,248 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"github.com/Electronic-Catalog/microkit/metric"
+	"golang.org/x/sync/singleflight"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// swrEnvelopePrefix marks values which are stored with soft expiry metadata, the digit is version of the format
+const swrEnvelopePrefix = "swr1:"
+
+type swrEntry struct {
+	value          string
+	softExpiration time.Time
+	hardExpiration time.Time
+}
+
+// encodeSWREntry stores expirations in unix milliseconds next to the value, "swr1:<soft>:<hard>:<value>"
+func encodeSWREntry(e swrEntry) string {
+	return swrEnvelopePrefix + strconv.FormatInt(e.softExpiration.UnixMilli(), 10) + ":" +
+		strconv.FormatInt(e.hardExpiration.UnixMilli(), 10) + ":" + e.value
+}
+
+func decodeSWREntry(raw string) (swrEntry, bool) {
+	if !strings.HasPrefix(raw, swrEnvelopePrefix) {
+		return swrEntry{}, false
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(raw, swrEnvelopePrefix), ":", 3)
+	if len(parts) != 3 {
+		return swrEntry{}, false
+	}
+	soft, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return swrEntry{}, false
+	}
+	hard, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return swrEntry{}, false
+	}
+
+	return swrEntry{
+		value:          parts[2],
+		softExpiration: time.UnixMilli(soft),
+		hardExpiration: time.UnixMilli(hard),
+	}, true
+}
+
+type staleWhileRevalidateConfig struct {
+	metric         metric.Metric
+	staleIfError   time.Duration
+	refreshTimeout time.Duration
+}
+
+type StaleWhileRevalidateOption func(*staleWhileRevalidateConfig)
+
+// WithStaleWhileRevalidateMetric
+// reports get, load, refresh and set ops of each method with the metric schema of backends (see OutcomeHit),
+// served stale values are counted as hits of stale and stale_if_error ops
+func WithStaleWhileRevalidateMetric(metric metric.Metric) StaleWhileRevalidateOption {
+	return func(sc *staleWhileRevalidateConfig) {
+		sc.metric = metric
+	}
+}
+
+// WithStaleIfError
+// keeps values for d after their hard ttl, they are only served when the loader fails
+func WithStaleIfError(d time.Duration) StaleWhileRevalidateOption {
+	return func(sc *staleWhileRevalidateConfig) {
+		sc.staleIfError = d
+	}
+}
+
+// WithRefreshTimeout
+// bounds loads, 30s by default. background refreshes and loads which are shared by concurrent misses of a key
+// are not canceled with the request which triggered them
+func WithRefreshTimeout(timeout time.Duration) StaleWhileRevalidateOption {
+	return func(sc *staleWhileRevalidateConfig) {
+		sc.refreshTimeout = timeout
+	}
+}
+
+// StaleWhileRevalidate
+// serves a cached value until its soft ttl like ReadThrough, between soft and hard ttl the stale value
+// is still served while a single background refresh runs, after hard ttl the value is loaded again.
+// values which are stale or kept by WithStaleIfError are served when the loader fails
+type StaleWhileRevalidate struct {
+	cache          Cache
+	group          singleflight.Group
+	metric         metric.Metric
+	softTTL        time.Duration
+	hardTTL        time.Duration
+	staleIfError   time.Duration
+	refreshTimeout time.Duration
+	now            func() time.Time
+
+	lock       *sync.Mutex
+	refreshing map[string]struct{}
+}
+
+func NewStaleWhileRevalidate(cache Cache, softTTL time.Duration, hardTTL time.Duration, options ...StaleWhileRevalidateOption) (*StaleWhileRevalidate, error) {
+	if softTTL <= 0 || hardTTL < softTTL {
+		return nil, fmt.Errorf("soft ttl must be positive and not longer than hard ttl, got %v and %v", softTTL, hardTTL)
+	}
+
+	conf := staleWhileRevalidateConfig{
+		metric:         metric.NewNop(),
+		refreshTimeout: time.Second * 30,
+	}
+	for _, op := range options {
+		op(&conf)
+	}
+
+	return &StaleWhileRevalidate{
+		cache:          cache,
+		metric:         conf.metric,
+		softTTL:        softTTL,
+		hardTTL:        hardTTL,
+		staleIfError:   conf.staleIfError,
+		refreshTimeout: conf.refreshTimeout,
+		now:            time.Now,
+		lock:           &sync.Mutex{},
+		refreshing:     make(map[string]struct{}),
+	}, nil
+}
+
+// GetOrLoad
+// returns the cached value of key, stale values trigger a background refresh and missed or hard expired
+// ones are loaded through loader, concurrent loads of a key share a single loader call
+// method :: used for metrics
+func (s *StaleWhileRevalidate) GetOrLoad(ctx context.Context, method string, key string, loader LoaderFunc) (string, error) {
+	var fallback *swrEntry
+
+	start := time.Now()
+	raw, err := s.cache.GetKey(ctx, method, key)
+	if err == nil {
+		// values which are not stored by this wrapper are treated as missed
+		if entry, ok := decodeSWREntry(raw); ok {
+			now := s.now()
+			switch {
+			case now.Before(entry.softExpiration):
+				recordOperation(s.metric, "swr", "get", method, start, nil)
+				return entry.value, nil
+			case now.Before(entry.hardExpiration):
+				recordOperation(s.metric, "swr", "get", method, start, nil)
+				s.metric.IncrementTotal("swr", "stale", method, OutcomeHit)
+				s.refresh(method, key, loader)
+				return entry.value, nil
+			case now.Before(entry.hardExpiration.Add(s.staleIfError)):
+				// kept by WithStaleIfError
+				fallback = &entry
+			}
+		}
+		err = NotFoundError
+	}
+	// on errors other than miss the cache is not available, we still are able to serve from loader
+	recordOperation(s.metric, "swr", "get", method, start, err)
+
+	// the load outlives a caller which gives up, the other waiters of key still receive its result
+	ch := s.group.DoChan(key, func() (interface{}, error) {
+		loadCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), s.refreshTimeout)
+		defer cancel()
+		return s.load(loadCtx, method, key, loader)
+	})
+
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			if fallback != nil {
+				s.metric.IncrementTotal("swr", "stale_if_error", method, OutcomeHit)
+				return fallback.value, nil
+			}
+			return "", res.Err
+		}
+		return res.Val.(string), nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// Set
+// stores a fresh value of key, e.g. after it is changed in the source of truth
+func (s *StaleWhileRevalidate) Set(ctx context.Context, method string, key string, val string) error {
+	return s.store(ctx, method, key, val)
+}
+
+// refresh
+// loads key in background unless a refresh of it is already running, a failed refresh
+// leaves the stale value in cache until its hard ttl
+func (s *StaleWhileRevalidate) refresh(method string, key string, loader LoaderFunc) {
+	s.lock.Lock()
+	if _, ok := s.refreshing[key]; ok {
+		s.lock.Unlock()
+		return
+	}
+	s.refreshing[key] = struct{}{}
+	s.lock.Unlock()
+
+	go func() {
+		defer func() {
+			s.lock.Lock()
+			delete(s.refreshing, key)
+			s.lock.Unlock()
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), s.refreshTimeout)
+		defer cancel()
+
+		start := time.Now()
+		_, err, _ := s.group.Do(key, func() (interface{}, error) {
+			return s.load(ctx, method, key, loader)
+		})
+		recordOperation(s.metric, "swr", "refresh", method, start, err)
+	}()
+}
+
+func (s *StaleWhileRevalidate) load(ctx context.Context, method string, key string, loader LoaderFunc) (string, error) {
+	start := time.Now()
+	val, err := loader(ctx)
+	recordOperation(s.metric, "swr", "load", method, start, err)
+	if err != nil {
+		return "", err
+	}
+
+	_ = s.store(ctx, method, key, val)
+
+	return val, nil
+}
+
+func (s *StaleWhileRevalidate) store(ctx context.Context, method string, key string, val string) error {
+	now := s.now()
+	entry := swrEntry{
+		value:          val,
+		softExpiration: now.Add(s.softTTL),
+		hardExpiration: now.Add(s.hardTTL),
+	}
+
+	start := time.Now()
+	err := s.cache.Set(ctx, method, key, encodeSWREntry(entry), s.hardTTL+s.staleIfError)
+	recordOperation(s.metric, "swr", "set", method, start, err)
+
+	return err
+}
@@ -0,0 +1,198 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"github.com/Electronic-Catalog/microkit/metric/metrictest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeClock is moved by tests, it is safe to read from background refreshes
+type fakeClock struct {
+	start  time.Time
+	offset atomic.Int64
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{start: time.Now()}
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.start.Add(time.Duration(c.offset.Load()))
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.offset.Add(int64(d))
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	ctx := context.Background()
+	mem, err := NewInMemoryCache(time.Minute)
+	require.NoError(t, err)
+	defer mem.Close()
+	mt := metrictest.NewRecorder()
+	swr, err := NewStaleWhileRevalidate(mem, time.Minute, time.Hour, WithStaleWhileRevalidateMetric(mt))
+	require.NoError(t, err)
+	clock := newFakeClock()
+	swr.now = clock.now
+
+	var loads atomic.Int32
+	release := make(chan struct{})
+	loader := func(ctx context.Context) (string, error) {
+		n := loads.Add(1)
+		if n > 1 {
+			<-release
+		}
+		return "v" + strconv.Itoa(int(n)), nil
+	}
+
+	val, err := swr.GetOrLoad(ctx, "nop", "product:1", loader)
+	require.NoError(t, err)
+	require.Equal(t, "v1", val)
+
+	val, err = swr.GetOrLoad(ctx, "nop", "product:1", loader)
+	require.NoError(t, err)
+	require.Equal(t, "v1", val)
+	require.Equal(t, int32(1), loads.Load())
+	require.Equal(t, 1, mt.Total("swr", "get", "nop", OutcomeMiss))
+	require.Equal(t, 1, mt.Total("swr", "get", "nop", OutcomeHit))
+
+	// after soft ttl the stale value is served while a single refresh runs
+	clock.advance(time.Minute * 2)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			val, err := swr.GetOrLoad(ctx, "nop", "product:1", loader)
+			assert.NoError(t, err)
+			assert.Equal(t, "v1", val)
+		}()
+	}
+	wg.Wait()
+	close(release)
+
+	require.Eventually(t, func() bool {
+		val, err := swr.GetOrLoad(ctx, "nop", "product:1", loader)
+		return err == nil && val == "v2"
+	}, time.Second, time.Millisecond*10)
+	require.Equal(t, int32(2), loads.Load())
+	require.Equal(t, 20, mt.Total("swr", "stale", "nop", OutcomeHit))
+}
+
+func TestStaleWhileRevalidateStaleIfError(t *testing.T) {
+	ctx := context.Background()
+	mem, err := NewInMemoryCache(time.Minute)
+	require.NoError(t, err)
+	defer mem.Close()
+	mt := metrictest.NewRecorder()
+	swr, err := NewStaleWhileRevalidate(mem, time.Minute, time.Hour,
+		WithStaleIfError(time.Hour*24), WithStaleWhileRevalidateMetric(mt))
+	require.NoError(t, err)
+	clock := newFakeClock()
+	swr.now = clock.now
+
+	require.NoError(t, swr.Set(ctx, "nop", "product:1", "v1"))
+	ttl, err := mem.TTL(ctx, "nop", "product:1")
+	require.NoError(t, err)
+	require.Greater(t, ttl, time.Hour*24)
+
+	failing := func(ctx context.Context) (string, error) {
+		return "", errors.New("upstream is down")
+	}
+
+	// a failed background refresh keeps the stale value
+	clock.advance(time.Minute * 2)
+	val, err := swr.GetOrLoad(ctx, "nop", "product:1", failing)
+	require.NoError(t, err)
+	require.Equal(t, "v1", val)
+	require.Eventually(t, func() bool {
+		return mt.Error("swr", "refresh", "nop", "error") == 1
+	}, time.Second, time.Millisecond*10)
+
+	// after hard ttl the value is loaded again and served only when loader fails
+	clock.advance(time.Hour)
+	val, err = swr.GetOrLoad(ctx, "nop", "product:1", failing)
+	require.NoError(t, err)
+	require.Equal(t, "v1", val)
+	require.Equal(t, 1, mt.Total("swr", "stale_if_error", "nop", OutcomeHit))
+
+	val, err = swr.GetOrLoad(ctx, "nop", "product:1", func(ctx context.Context) (string, error) {
+		return "v2", nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, "v2", val)
+
+	// nothing is served after the stale-if-error window
+	clock.advance(time.Hour * 26)
+	_, err = swr.GetOrLoad(ctx, "nop", "product:1", failing)
+	require.Error(t, err)
+}
+
+func TestStaleWhileRevalidateCanceledCaller(t *testing.T) {
+	mem, err := NewInMemoryCache(time.Minute)
+	require.NoError(t, err)
+	defer mem.Close()
+	swr, err := NewStaleWhileRevalidate(mem, time.Minute, time.Hour)
+	require.NoError(t, err)
+
+	started := make(chan struct{})
+	loader := func(ctx context.Context) (string, error) {
+		close(started)
+		select {
+		case <-time.After(time.Millisecond * 100):
+			return "loaded", nil
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+
+	// the caller which starts the load gives up, the other waiter still gets the value
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := swr.GetOrLoad(ctx, "nop", "product:1", loader)
+		first <- err
+	}()
+	<-started
+
+	second := make(chan string, 1)
+	go func() {
+		val, err := swr.GetOrLoad(context.Background(), "nop", "product:1", loader)
+		assert.NoError(t, err)
+		second <- val
+	}()
+	time.Sleep(time.Millisecond * 10)
+	cancel()
+
+	require.ErrorIs(t, <-first, context.Canceled)
+	require.Equal(t, "loaded", <-second)
+}
+
+func TestStaleWhileRevalidateForeignValue(t *testing.T) {
+	ctx := context.Background()
+	mem, err := NewInMemoryCache(time.Minute)
+	require.NoError(t, err)
+	defer mem.Close()
+	swr, err := NewStaleWhileRevalidate(mem, time.Minute, time.Hour)
+	require.NoError(t, err)
+
+	// values which are written without the wrapper are loaded again
+	require.NoError(t, mem.Set(ctx, "nop", "product:1", "raw", time.Minute))
+	val, err := swr.GetOrLoad(ctx, "nop", "product:1", func(ctx context.Context) (string, error) {
+		return "loaded", nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, "loaded", val)
+
+	_, err = NewStaleWhileRevalidate(mem, time.Hour, time.Minute)
+	require.Error(t, err)
+	_, err = NewStaleWhileRevalidate(mem, 0, time.Minute)
+	require.Error(t, err)
+}
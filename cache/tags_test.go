@@ -0,0 +1,86 @@
+package cache
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestTaggedCache(t *testing.T) {
+	srv := miniredis.RunT(t)
+	rd, err := NewRedisCache(WithAddresses(nil, srv.Addr()))
+	require.NoError(t, err)
+	cluster, err := NewRedisCache(WithClusterAddresses(srv.Addr()))
+	require.NoError(t, err)
+	mem, err := NewInMemoryCache(time.Second)
+	require.NoError(t, err)
+	sharded, err := NewInMemoryCache(time.Second, WithShards(4))
+	require.NoError(t, err)
+
+	caches := map[string]Cache{
+		"redis":   rd,
+		"cluster": cluster,
+		"mem":     mem,
+		"sharded": sharded,
+	}
+
+	for name, c := range caches {
+		t.Run(name, func(t *testing.T) {
+			srv.FlushAll()
+			ctx := context.Background()
+			tc := c.(TaggedCache)
+
+			require.NoError(t, tc.SetWithTags(ctx, "nop", "product:1", "p1", time.Minute, "category:42", "vendor:7"))
+			require.NoError(t, tc.SetWithTags(ctx, "nop", "product:2", "p2", time.Minute, "category:42"))
+			require.NoError(t, tc.SetWithTags(ctx, "nop", "product:3", "p3", time.Minute, "vendor:7"))
+			require.NoError(t, tc.Set(ctx, "nop", "product:4", "p4", time.Minute))
+
+			require.NoError(t, tc.InvalidateTags(ctx, "nop", "category:42"))
+
+			got, err := MGet(ctx, tc, "nop", "product:1", "product:2", "product:3", "product:4")
+			require.NoError(t, err)
+			require.Equal(t, map[string]string{"product:3": "p3", "product:4": "p4"}, got)
+
+			require.NoError(t, tc.InvalidateTags(ctx, "nop", "vendor:7", "unknown"))
+			_, err = tc.GetKey(ctx, "nop", "product:3")
+			require.ErrorIs(t, err, NotFoundError)
+		})
+	}
+}
+
+func TestRedisTagSetExpiry(t *testing.T) {
+	srv := miniredis.RunT(t)
+	rd, err := NewRedisCache(WithAddresses(nil, srv.Addr()))
+	require.NoError(t, err)
+	tc := rd.(TaggedCache)
+	ctx := context.Background()
+
+	require.NoError(t, tc.SetWithTags(ctx, "nop", "product:1", "p1", time.Minute, "category:42"))
+	require.Equal(t, time.Minute, srv.TTL(tagKey("category:42")))
+
+	// the set lives as long as its longest living member
+	require.NoError(t, tc.SetWithTags(ctx, "nop", "product:2", "p2", time.Hour, "category:42"))
+	require.Equal(t, time.Hour, srv.TTL(tagKey("category:42")))
+	require.NoError(t, tc.SetWithTags(ctx, "nop", "product:3", "p3", time.Second, "category:42"))
+	require.Equal(t, time.Hour, srv.TTL(tagKey("category:42")))
+
+	// members without expiration make the set persistent
+	require.NoError(t, tc.SetWithTags(ctx, "nop", "product:4", "p4", 0, "category:42"))
+	require.Equal(t, time.Duration(0), srv.TTL(tagKey("category:42")))
+
+	// expired members are pruned when the tag is used again
+	srv.FastForward(time.Minute * 2)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, tc.SetWithTags(ctx, "nop", "product:4", "p4", 0, "category:42"))
+	}
+	members, err := srv.Members(tagKey("category:42"))
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"product:2", "product:4"}, members)
+
+	// a set of expiring members is removed by redis after its members
+	require.NoError(t, tc.SetWithTags(ctx, "nop", "product:5", "p5", time.Minute, "vendor:7"))
+	srv.FastForward(time.Minute)
+	require.False(t, srv.Exists(tagKey("vendor:7")))
+}
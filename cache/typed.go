@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+type typedConfig struct {
+	compressor Compressor
+}
+
+type TypedOption func(*typedConfig)
+
+// WithCompressor
+// compresses encoded values before they are stored in the underlying cache
+func WithCompressor(compressor Compressor) TypedOption {
+	return func(tc *typedConfig) {
+		tc.compressor = compressor
+	}
+}
+
+// TypedCache
+// stores values of type T in any Cache implementation, values are encoded with the given codec
+// so callers don't have to marshal and unmarshal them by hand
+type TypedCache[T any] struct {
+	cache      Cache
+	codec      Codec
+	compressor Compressor
+}
+
+func NewTypedCache[T any](cache Cache, codec Codec, options ...TypedOption) *TypedCache[T] {
+	var conf typedConfig
+	for _, op := range options {
+		op(&conf)
+	}
+
+	return &TypedCache[T]{
+		cache:      cache,
+		codec:      codec,
+		compressor: conf.compressor,
+	}
+}
+
+// GetKey
+// reads and decodes the value of key, NotFoundError is returned as is when key does not exist
+// and a *DecodeError when the stored value could not be decoded
+func (t *TypedCache[T]) GetKey(ctx context.Context, method string, key string) (T, error) {
+	var val T
+
+	raw, err := t.cache.GetKey(ctx, method, key)
+	if err != nil {
+		return val, err
+	}
+
+	val, err = t.decode(raw)
+	if err != nil {
+		return val, &DecodeError{Key: key, Err: err}
+	}
+
+	return val, nil
+}
+
+// Set
+// encodes val and stores it in the underlying cache
+func (t *TypedCache[T]) Set(ctx context.Context, method string, key string, val T, expiration time.Duration) error {
+	raw, err := t.encode(val)
+	if err != nil {
+		return fmt.Errorf("could not encode value of key %q: %w", key, err)
+	}
+
+	return t.cache.Set(ctx, method, key, raw, expiration)
+}
+
+func (t *TypedCache[T]) RemoveKey(ctx context.Context, method string, key string) error {
+	return t.cache.RemoveKey(ctx, method, key)
+}
+
+// Cache
+// returns the underlying cache
+func (t *TypedCache[T]) Cache() Cache {
+	return t.cache
+}
+
+func (t *TypedCache[T]) encode(val T) (string, error) {
+	data, err := t.codec.Marshal(val)
+	if err != nil {
+		return "", err
+	}
+
+	if t.compressor != nil {
+		data, err = t.compressor.Compress(data)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return string(data), nil
+}
+
+func (t *TypedCache[T]) decode(raw string) (T, error) {
+	var val T
+	data := []byte(raw)
+
+	if t.compressor != nil {
+		var err error
+		data, err = t.compressor.Decompress(data)
+		if err != nil {
+			return val, err
+		}
+	}
+
+	err := t.codec.Unmarshal(data, &val)
+	return val, err
+}
@@ -0,0 +1,79 @@
+package cache
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"testing"
+	"time"
+)
+
+type product struct {
+	ID    int64
+	Name  string
+	Price float64
+	Tags  []string
+}
+
+func TestTypedCache(t *testing.T) {
+	mem, err := NewInMemoryCache(time.Second)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	gz, err := NewGzipCompressor(gzip.BestSpeed)
+	require.NoError(t, err)
+
+	codecs := map[string]Codec{
+		"json":    NewJSONCodec(),
+		"gob":     NewGobCodec(),
+		"msgpack": NewMsgpackCodec(),
+	}
+
+	want := product{ID: 42, Name: "keyboard", Price: 12.5, Tags: []string{"usb", "black"}}
+	for name, codec := range codecs {
+		t.Run(name, func(t *testing.T) {
+			tc := NewTypedCache[product](mem, codec)
+			require.NoError(t, tc.Set(ctx, "nop", name, want, time.Minute))
+
+			got, err := tc.GetKey(ctx, "nop", name)
+			require.NoError(t, err)
+			require.Equal(t, want, got)
+
+			compressed := NewTypedCache[product](mem, codec, WithCompressor(gz))
+			require.NoError(t, compressed.Set(ctx, "nop", name+"-gz", want, time.Minute))
+
+			got, err = compressed.GetKey(ctx, "nop", name+"-gz")
+			require.NoError(t, err)
+			require.Equal(t, want, got)
+		})
+	}
+
+	t.Run("proto", func(t *testing.T) {
+		tc := NewTypedCache[*wrapperspb.StringValue](mem, NewProtoCodec())
+		require.NoError(t, tc.Set(ctx, "nop", "proto", wrapperspb.String("hello"), time.Minute))
+
+		got, err := tc.GetKey(ctx, "nop", "proto")
+		require.NoError(t, err)
+		require.Equal(t, "hello", got.GetValue())
+	})
+}
+
+func TestTypedCacheErrors(t *testing.T) {
+	mem, err := NewInMemoryCache(time.Second)
+	require.NoError(t, err)
+	ctx := context.Background()
+	tc := NewTypedCache[product](mem, NewJSONCodec())
+
+	_, err = tc.GetKey(ctx, "nop", "missing")
+	require.ErrorIs(t, err, NotFoundError)
+
+	require.NoError(t, mem.Set(ctx, "nop", "broken", "{not-json", time.Minute))
+	_, err = tc.GetKey(ctx, "nop", "broken")
+
+	var decodeErr *DecodeError
+	require.True(t, errors.As(err, &decodeErr))
+	require.Equal(t, "broken", decodeErr.Key)
+	require.False(t, errors.Is(err, NotFoundError))
+}
@@ -92,7 +92,7 @@ func Decrypt(ciphertext string, key32 []byte) (plaintext string, err error) {
 		return
 	}
 
-	if len(ciphertext) < aesGCM.NonceSize() {
+	if len(s) < aesGCM.NonceSize()+aesGCM.Overhead() {
 		err = errors.New("too short")
 		return
 	}
@@ -0,0 +1,358 @@
+// Package httpcache provides a net/http middleware which caches GET responses in a cache.Cache,
+// it honours Cache-Control of requests and responses and answers conditional requests with 304.
+package httpcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/Electronic-Catalog/microkit/cache"
+	"github.com/Electronic-Catalog/microkit/metric"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// entry is a cached response
+type entry struct {
+	Status int
+	Header http.Header
+	Body   []byte
+	// StoredAt is unix time in milliseconds, it gives Age of the response
+	StoredAt int64
+}
+
+// cacheableStatuses are cacheable by default (RFC 9110 section 15.1)
+var cacheableStatuses = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusNotFound:             true,
+	http.StatusMethodNotAllowed:     true,
+	http.StatusGone:                 true,
+	http.StatusRequestURITooLong:    true,
+	http.StatusNotImplemented:       true,
+}
+
+type config struct {
+	ttl         time.Duration
+	vary        []string
+	method      string
+	maxBodySize int
+	metric      metric.Metric
+	now         func() time.Time
+}
+
+type Option func(*config)
+
+// WithTTL
+// lifetime of responses without max-age or s-maxage in their Cache-Control, 1m by default
+func WithTTL(ttl time.Duration) Option {
+	return func(c *config) {
+		c.ttl = ttl
+	}
+}
+
+// WithVary
+// request headers which are a part of the cache key, e.g. Accept-Language. responses which Vary by
+// other headers are not cached, because they would be served to requests with other values of those headers
+func WithVary(headers ...string) Option {
+	return func(c *config) {
+		for _, header := range headers {
+			c.vary = append(c.vary, http.CanonicalHeaderKey(header))
+		}
+	}
+}
+
+// WithMethod
+// method of cache calls, it is used for metrics, "http_cache" by default
+func WithMethod(method string) Option {
+	return func(c *config) {
+		c.method = method
+	}
+}
+
+// WithMaxBodySize
+// responses with larger bodies (1MB by default) are streamed to the client and not cached
+func WithMaxBodySize(size int) Option {
+	return func(c *config) {
+		c.maxBodySize = size
+	}
+}
+
+// WithMetric
+// counts hits, misses and bypassed requests of the method
+func WithMetric(metric metric.Metric) Option {
+	return func(c *config) {
+		c.metric = metric
+	}
+}
+
+type middleware struct {
+	cache  *cache.TypedCache[entry]
+	config config
+}
+
+// NewMiddleware
+// caches responses of GET requests by method, path, query and headers of WithVary, e.g.
+//
+//	mw, err := httpcache.NewMiddleware(c, httpcache.WithVary("Accept-Language"))
+//	http.Handle("/products", mw(productsHandler))
+//
+// responses which are private, no-store, no-cache or set cookies are not cached, max-age and s-maxage
+// of responses set their lifetime. responses to requests with Authorization are only cached when they are
+// public, must-revalidate or have s-maxage. requests with no-cache or max-age=0 bypass the cached response and
+// refresh it, and no-store requests bypass the cache completely.
+// responses get an ETag (a hash of the body unless the handler sets one), a matching If-None-Match
+// is answered with 304, X-Cache tells whether the response is served from cache
+func NewMiddleware(c cache.Cache, options ...Option) (func(http.Handler) http.Handler, error) {
+	m, err := newMiddleware(c, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.handler, nil
+}
+
+func newMiddleware(c cache.Cache, options []Option) (*middleware, error) {
+	conf := config{
+		ttl:         time.Minute,
+		method:      "http_cache",
+		maxBodySize: 1 << 20,
+		metric:      metric.NewNop(),
+		now:         time.Now,
+	}
+	for _, op := range options {
+		op(&conf)
+	}
+
+	if conf.ttl <= 0 {
+		return nil, fmt.Errorf("ttl must be positive, got %v", conf.ttl)
+	}
+	if conf.maxBodySize <= 0 {
+		return nil, fmt.Errorf("max body size must be positive, got %d", conf.maxBodySize)
+	}
+
+	return &middleware{
+		cache:  cache.NewTypedCache[entry](c, cache.NewMsgpackCodec()),
+		config: conf,
+	}, nil
+}
+
+func (m *middleware) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.serveHTTP(w, r, next)
+	})
+}
+
+func (m *middleware) serveHTTP(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	directives := parseCacheControl(r.Header.Values("Cache-Control"))
+	if r.Method != http.MethodGet || directives.has("no-store") {
+		m.config.metric.IncrementTotal("httpcache", m.config.method, "bypass")
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	key := m.key(r)
+	now := m.config.now()
+	revalidate := directives.has("no-cache") || r.Header.Get("Pragma") == "no-cache"
+	if !revalidate {
+		e, err := m.cache.GetKey(r.Context(), m.config.method, key)
+		if err == nil && m.acceptable(e, directives, now) {
+			m.config.metric.IncrementTotal("httpcache", m.config.method, "hit")
+			m.write(w, r, e, "HIT", now)
+			return
+		}
+
+		var decodeErr *cache.DecodeError
+		if err != nil && !errors.Is(err, cache.NotFoundError) && !errors.As(err, &decodeErr) {
+			m.config.metric.IncrementError("httpcache", m.config.method, "load")
+		}
+	}
+	m.config.metric.IncrementTotal("httpcache", m.config.method, "miss")
+
+	rec := newRecorder(w, m.config.maxBodySize)
+	next.ServeHTTP(rec, r)
+	if rec.streaming {
+		return
+	}
+
+	e := rec.entry(now)
+	if e.Status == http.StatusOK && e.Header.Get("ETag") == "" {
+		e.Header.Set("ETag", etag(e.Body))
+	}
+
+	if ttl := m.ttl(e, r.Header.Get("Authorization") != ""); ttl > 0 {
+		err := m.cache.Set(r.Context(), m.config.method, key, e, ttl)
+		if err != nil {
+			// the response is still served, it is cached by one of the next requests
+			m.config.metric.IncrementError("httpcache", m.config.method, "store")
+		}
+	}
+
+	m.write(w, r, e, "MISS", now)
+}
+
+// key
+// the query is encoded with sorted keys, so the order of parameters doesn't matter
+func (m *middleware) key(r *http.Request) string {
+	parts := []string{"http", r.Method, r.URL.Path, r.URL.Query().Encode()}
+	for _, header := range m.config.vary {
+		parts = append(parts, strings.Join(r.Header.Values(header), ","))
+	}
+
+	return cache.Key(parts...)
+}
+
+// acceptable reports whether a cached response satisfies max-age of the request
+func (m *middleware) acceptable(e entry, directives cacheControl, now time.Time) bool {
+	maxAge, ok := directives.seconds("max-age")
+	if !ok {
+		return true
+	}
+
+	return age(e, now) <= maxAge
+}
+
+// ttl
+// returns how long response is cached, zero means it is not cacheable. responses to authorized requests
+// are only cached when they allow shared caches explicitly (RFC 9111 section 3.5), because the key
+// doesn't include credentials and the response would be served to other clients
+func (m *middleware) ttl(e entry, authorized bool) time.Duration {
+	if !cacheableStatuses[e.Status] || e.Header.Get("Set-Cookie") != "" {
+		return 0
+	}
+
+	for _, header := range e.Header.Values("Vary") {
+		for _, name := range strings.Split(header, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			if name == "*" || !m.varies(name) {
+				return 0
+			}
+		}
+	}
+
+	directives := parseCacheControl(e.Header.Values("Cache-Control"))
+	if directives.has("no-store") || directives.has("private") || directives.has("no-cache") {
+		return 0
+	}
+	if authorized && !directives.has("public") && !directives.has("s-maxage") && !directives.has("must-revalidate") {
+		return 0
+	}
+	// s-maxage is meant for shared caches like this one
+	if ttl, ok := directives.seconds("s-maxage"); ok {
+		return ttl
+	}
+	if ttl, ok := directives.seconds("max-age"); ok {
+		return ttl
+	}
+
+	return m.config.ttl
+}
+
+func (m *middleware) varies(header string) bool {
+	for _, vary := range m.config.vary {
+		if vary == header {
+			return true
+		}
+	}
+
+	return false
+}
+
+// write sends e to the client, or 304 when it matches If-None-Match of the request
+func (m *middleware) write(w http.ResponseWriter, r *http.Request, e entry, status string, now time.Time) {
+	h := w.Header()
+	for name, values := range e.Header {
+		h[name] = values
+	}
+	h.Set("X-Cache", status)
+	if status == "HIT" {
+		h.Set("Age", strconv.Itoa(int(age(e, now).Seconds())))
+	}
+
+	if e.Status == http.StatusOK && etagMatches(r.Header.Get("If-None-Match"), e.Header.Get("ETag")) {
+		// 304 keeps validators and caching headers but has no body
+		h.Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.WriteHeader(e.Status)
+	_, _ = w.Write(e.Body)
+}
+
+func age(e entry, now time.Time) time.Duration {
+	return max(now.Sub(time.UnixMilli(e.StoredAt)), 0)
+}
+
+// etag returns a strong validator of body
+func etag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches
+// compares If-None-Match with etag weakly, as RFC 9110 requires for If-None-Match
+func etagMatches(ifNoneMatch string, etag string) bool {
+	if ifNoneMatch == "" || etag == "" {
+		return false
+	}
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cacheControl keeps directives of Cache-Control headers by their lower-case name
+type cacheControl map[string]string
+
+func parseCacheControl(headers []string) cacheControl {
+	directives := cacheControl{}
+	for _, header := range headers {
+		for _, directive := range strings.Split(header, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if name == "" {
+				continue
+			}
+			directives[strings.ToLower(name)] = strings.Trim(value, `"`)
+		}
+	}
+
+	return directives
+}
+
+func (c cacheControl) has(name string) bool {
+	_, ok := c[name]
+	return ok
+}
+
+// seconds returns a delta-seconds directive, invalid values are ignored
+func (c cacheControl) seconds(name string) (time.Duration, bool) {
+	value, ok := c[name]
+	if !ok {
+		return 0, false
+	}
+
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+
+	return time.Duration(seconds) * time.Second, true
+}
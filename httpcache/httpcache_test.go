@@ -0,0 +1,238 @@
+package httpcache
+
+import (
+	"github.com/Electronic-Catalog/microkit/cache"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingHandler answers with the language of the request and counts its calls
+type countingHandler struct {
+	calls        atomic.Int32
+	cacheControl string
+}
+
+func (h *countingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.calls.Add(1)
+	if h.cacheControl != "" {
+		w.Header().Set("Cache-Control", h.cacheControl)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Vary", "Accept-Language")
+	_, _ = w.Write([]byte(`{"lang":"` + r.Header.Get("Accept-Language") + `","q":"` + r.URL.RawQuery + `"}`))
+}
+
+func newTestMiddleware(t *testing.T, options ...Option) func(http.Handler) http.Handler {
+	mem, err := cache.NewInMemoryCache(time.Minute)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = mem.Close()
+	})
+
+	mw, err := NewMiddleware(mem, append([]Option{WithVary("accept-language")}, options...)...)
+	require.NoError(t, err)
+
+	return mw
+}
+
+func get(h http.Handler, target string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestCacheResponses(t *testing.T) {
+	handler := &countingHandler{}
+	h := newTestMiddleware(t)(handler)
+
+	res := get(h, "/products?b=2&a=1", "Accept-Language", "fa")
+	require.Equal(t, http.StatusOK, res.Code)
+	require.Equal(t, "MISS", res.Header().Get("X-Cache"))
+	require.NotEmpty(t, res.Header().Get("ETag"))
+
+	// the order of query parameters doesn't matter
+	res = get(h, "/products?a=1&b=2", "Accept-Language", "fa")
+	require.Equal(t, "HIT", res.Header().Get("X-Cache"))
+	require.Equal(t, "application/json", res.Header().Get("Content-Type"))
+	require.Equal(t, `{"lang":"fa","q":"b=2&a=1"}`, res.Body.String())
+	require.Equal(t, int32(1), handler.calls.Load())
+
+	// vary headers and the path are a part of the key
+	res = get(h, "/products?a=1&b=2", "Accept-Language", "en")
+	require.Equal(t, "MISS", res.Header().Get("X-Cache"))
+	require.Equal(t, `{"lang":"en","q":"a=1&b=2"}`, res.Body.String())
+	get(h, "/brands?a=1&b=2", "Accept-Language", "fa")
+	require.Equal(t, int32(3), handler.calls.Load())
+
+	// only GET is cached
+	req := httptest.NewRequest(http.MethodPost, "/products?a=1&b=2", nil)
+	req.Header.Set("Accept-Language", "fa")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	require.Empty(t, rec.Header().Get("X-Cache"))
+	require.Equal(t, int32(4), handler.calls.Load())
+}
+
+func TestETag(t *testing.T) {
+	handler := &countingHandler{}
+	h := newTestMiddleware(t)(handler)
+
+	res := get(h, "/products", "Accept-Language", "fa")
+	etag := res.Header().Get("ETag")
+
+	for _, ifNoneMatch := range []string{etag, `"other", ` + etag, "W/" + etag, "*"} {
+		res = get(h, "/products", "Accept-Language", "fa", "If-None-Match", ifNoneMatch)
+		require.Equal(t, http.StatusNotModified, res.Code, ifNoneMatch)
+		require.Empty(t, res.Body.String())
+		require.Equal(t, etag, res.Header().Get("ETag"))
+	}
+
+	res = get(h, "/products", "Accept-Language", "fa", "If-None-Match", `"other"`)
+	require.Equal(t, http.StatusOK, res.Code)
+
+	// a miss is answered with 304 as well
+	res = get(h, "/products", "Accept-Language", "fa", "Cache-Control", "no-cache", "If-None-Match", etag)
+	require.Equal(t, http.StatusNotModified, res.Code)
+	require.Equal(t, "MISS", res.Header().Get("X-Cache"))
+}
+
+func TestResponseCacheControl(t *testing.T) {
+	tests := []struct {
+		cacheControl string
+		cached       bool
+	}{
+		{"", true},
+		{"public, max-age=60", true},
+		{"max-age=0", false},
+		{"no-store", false},
+		{"private, max-age=60", false},
+		{"no-cache", false},
+		{"s-maxage=60, max-age=0", true},
+	}
+
+	for _, test := range tests {
+		handler := &countingHandler{cacheControl: test.cacheControl}
+		h := newTestMiddleware(t)(handler)
+
+		get(h, "/products")
+		res := get(h, "/products")
+		require.Equal(t, test.cached, res.Header().Get("X-Cache") == "HIT", test.cacheControl)
+	}
+}
+
+func TestAuthorizedRequests(t *testing.T) {
+	tests := []struct {
+		cacheControl string
+		cached       bool
+	}{
+		{"", false},
+		{"max-age=60", false},
+		{"public, max-age=60", true},
+		{"s-maxage=60", true},
+		{"must-revalidate, max-age=60", true},
+		{"private, public", false},
+	}
+
+	for _, test := range tests {
+		handler := &countingHandler{cacheControl: test.cacheControl}
+		h := newTestMiddleware(t)(handler)
+
+		get(h, "/products", "Authorization", "Bearer user-1")
+		res := get(h, "/products")
+		require.Equal(t, test.cached, res.Header().Get("X-Cache") == "HIT", test.cacheControl)
+	}
+}
+
+func TestResponseLifetime(t *testing.T) {
+	mem, err := cache.NewInMemoryCache(time.Minute)
+	require.NoError(t, err)
+	defer mem.Close()
+	m, err := newMiddleware(mem, []Option{WithVary("Accept-Language")})
+	require.NoError(t, err)
+	now := time.Now()
+	m.config.now = func() time.Time {
+		return now
+	}
+
+	handler := &countingHandler{cacheControl: "max-age=1"}
+	h := m.handler(handler)
+
+	get(h, "/products")
+	now = now.Add(time.Millisecond * 500)
+	res := get(h, "/products")
+	require.Equal(t, "HIT", res.Header().Get("X-Cache"))
+	require.Equal(t, "0", res.Header().Get("Age"))
+
+	// a request with max-age only accepts younger responses
+	res = get(h, "/products", "Cache-Control", "max-age=0")
+	require.Equal(t, "MISS", res.Header().Get("X-Cache"))
+
+	// max-age of the response is the ttl of the entry
+	time.Sleep(time.Millisecond * 1100)
+	res = get(h, "/products")
+	require.Equal(t, "MISS", res.Header().Get("X-Cache"))
+	require.Equal(t, int32(3), handler.calls.Load())
+}
+
+func TestRequestCacheControl(t *testing.T) {
+	handler := &countingHandler{}
+	h := newTestMiddleware(t)(handler)
+
+	get(h, "/products")
+	res := get(h, "/products", "Cache-Control", "no-store")
+	require.Empty(t, res.Header().Get("X-Cache"))
+	res = get(h, "/products", "Cache-Control", "no-cache")
+	require.Equal(t, "MISS", res.Header().Get("X-Cache"))
+	res = get(h, "/products")
+	require.Equal(t, "HIT", res.Header().Get("X-Cache"))
+	require.Equal(t, int32(3), handler.calls.Load())
+}
+
+func TestUncacheableResponses(t *testing.T) {
+	h := newTestMiddleware(t, WithMaxBodySize(8))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/cookie":
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "1"})
+		case "/vary":
+			w.Header().Set("Vary", "Authorization")
+		case "/error":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/large":
+			_, _ = w.Write([]byte(strings.Repeat("x", 20)))
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+
+	for _, path := range []string{"/cookie", "/vary", "/error", "/large"} {
+		first := get(h, path)
+		res := get(h, path)
+		require.Equal(t, "MISS", res.Header().Get("X-Cache"), path)
+		require.Equal(t, first.Code, res.Code, path)
+	}
+
+	// a large response is streamed as it is
+	res := get(h, "/large")
+	require.Equal(t, strings.Repeat("x", 20), res.Body.String())
+}
+
+func TestInvalidOptions(t *testing.T) {
+	mem, err := cache.NewInMemoryCache(time.Minute)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	_, err = NewMiddleware(mem, WithTTL(0))
+	require.Error(t, err)
+	_, err = NewMiddleware(mem, WithMaxBodySize(-1))
+	require.Error(t, err)
+}
@@ -0,0 +1,98 @@
+package httpcache
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+)
+
+// recorder
+// buffers a response, so it can be cached and its ETag is known before it is sent.
+// a response which outgrows max body size or is flushed by the handler is streamed to the client instead
+type recorder struct {
+	w           http.ResponseWriter
+	header      http.Header
+	status      int
+	body        bytes.Buffer
+	maxBodySize int
+	streaming   bool
+}
+
+func newRecorder(w http.ResponseWriter, maxBodySize int) *recorder {
+	return &recorder{
+		w:           w,
+		header:      http.Header{},
+		maxBodySize: maxBodySize,
+	}
+}
+
+func (r *recorder) Header() http.Header {
+	if r.streaming {
+		return r.w.Header()
+	}
+
+	return r.header
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if r.status != 0 {
+		return
+	}
+
+	r.status = status
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+
+	if !r.streaming && r.body.Len()+len(p) > r.maxBodySize {
+		r.stream()
+	}
+	if r.streaming {
+		return r.w.Write(p)
+	}
+
+	return r.body.Write(p)
+}
+
+// Flush
+// handlers flush to stream responses, so they are sent as they are and not cached
+func (r *recorder) Flush() {
+	r.WriteHeader(http.StatusOK)
+	r.stream()
+	if flusher, ok := r.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// stream sends the buffered part of the response and passes the rest through
+func (r *recorder) stream() {
+	if r.streaming {
+		return
+	}
+
+	r.streaming = true
+	h := r.w.Header()
+	for name, values := range r.header {
+		h[name] = values
+	}
+	h.Set("X-Cache", "MISS")
+	r.w.WriteHeader(r.status)
+	_, _ = r.w.Write(r.body.Bytes())
+	r.body.Reset()
+}
+
+// entry returns the buffered response
+func (r *recorder) entry(now time.Time) entry {
+	status := r.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	return entry{
+		Status:   status,
+		Header:   r.header,
+		Body:     r.body.Bytes(),
+		StoredAt: now.UnixMilli(),
+	}
+}
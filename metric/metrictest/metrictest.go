@@ -0,0 +1,53 @@
+// Package metrictest provides a metric.Metric which records calls, so tests are able to check what is reported
+package metrictest
+
+import (
+	"github.com/Electronic-Catalog/microkit/metric"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Recorder
+// counts IncrementTotal and IncrementError calls by their labels which are joined by "/",
+// response times are not recorded. it is safe for concurrent use
+type Recorder struct {
+	lock   sync.Mutex
+	totals map[string]int
+	errors map[string]int
+}
+
+var _ metric.Metric = (*Recorder)(nil)
+
+func NewRecorder() *Recorder {
+	return &Recorder{totals: map[string]int{}, errors: map[string]int{}}
+}
+
+func (r *Recorder) IncrementTotal(labelValues ...string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.totals[strings.Join(labelValues, "/")]++
+}
+
+func (r *Recorder) IncrementError(errorLabelValues ...string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.errors[strings.Join(errorLabelValues, "/")]++
+}
+
+func (r *Recorder) ObserveResponseTime(duration time.Duration, labelValues ...string) {
+}
+
+// Total returns the number of IncrementTotal calls with labelValues
+func (r *Recorder) Total(labelValues ...string) int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.totals[strings.Join(labelValues, "/")]
+}
+
+// Error returns the number of IncrementError calls with errorLabelValues
+func (r *Recorder) Error(errorLabelValues ...string) int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.errors[strings.Join(errorLabelValues, "/")]
+}
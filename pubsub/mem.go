@@ -0,0 +1,116 @@
+package pubsub
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type memPubSub struct {
+	conf     config
+	registry *registry
+
+	lock *sync.RWMutex
+	subs map[string]map[*memSubscriber]struct{}
+}
+
+type memSubscriber struct {
+	ctx      context.Context
+	messages chan Message
+}
+
+// NewInMemoryPubSub
+// delivers messages to subscribers of the same process, e.g. in tests or in a single replica service.
+// each subscription queues up to buffer size messages, Publish waits for a full queue until ctx is done
+func NewInMemoryPubSub(options ...Option) (PubSub, error) {
+	conf, err := newConfig(options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &memPubSub{
+		conf:     conf,
+		registry: newRegistry(),
+		lock:     &sync.RWMutex{},
+		subs:     make(map[string]map[*memSubscriber]struct{}),
+	}, nil
+}
+
+func (m *memPubSub) Publish(ctx context.Context, topic string, msg []byte) error {
+	if m.registry.isClosed() {
+		return ClosedError
+	}
+
+	startTime := time.Now()
+	err := m.publish(ctx, Message{Topic: topic, Payload: append([]byte(nil), msg...)})
+	m.conf.observePublish(topic, startTime, err)
+
+	return err
+}
+
+func (m *memPubSub) publish(ctx context.Context, msg Message) error {
+	// subscribers are copied, so a slow one doesn't keep others from subscribing
+	m.lock.RLock()
+	subscribers := make([]*memSubscriber, 0, len(m.subs[msg.Topic]))
+	for sub := range m.subs[msg.Topic] {
+		subscribers = append(subscribers, sub)
+	}
+	m.lock.RUnlock()
+
+	for _, sub := range subscribers {
+		select {
+		case sub.messages <- msg:
+		case <-sub.ctx.Done():
+			// closed meanwhile
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+func (m *memPubSub) Subscribe(ctx context.Context, topic string, handler Handler) (Subscription, error) {
+	s, ctx, err := m.registry.add(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sub := &memSubscriber{ctx: ctx, messages: make(chan Message, m.conf.bufferSize)}
+	m.lock.Lock()
+	if m.subs[topic] == nil {
+		m.subs[topic] = make(map[*memSubscriber]struct{})
+	}
+	m.subs[topic][sub] = struct{}{}
+	m.lock.Unlock()
+
+	go m.receive(s, sub, topic, handler)
+
+	return s, nil
+}
+
+func (m *memPubSub) Close() error {
+	m.registry.close()
+	return nil
+}
+
+func (m *memPubSub) receive(s *subscription, sub *memSubscriber, topic string, handler Handler) {
+	defer m.registry.remove(s)
+	defer func() {
+		m.lock.Lock()
+		delete(m.subs[topic], sub)
+		if len(m.subs[topic]) == 0 {
+			delete(m.subs, topic)
+		}
+		m.lock.Unlock()
+	}()
+
+	for {
+		select {
+		case <-sub.ctx.Done():
+			return
+		case msg := <-sub.messages:
+			m.conf.dispatch(sub.ctx, handler, msg)
+		}
+	}
+}
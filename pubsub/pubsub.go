@@ -0,0 +1,247 @@
+// Package pubsub provides fire-and-forget publish/subscribe messaging on redis pub/sub
+// or in memory of a single process, e.g. for change notifications between replicas of services.
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Electronic-Catalog/microkit/logger"
+	"github.com/Electronic-Catalog/microkit/logger/keyval"
+	"github.com/Electronic-Catalog/microkit/logger/zap"
+	"github.com/Electronic-Catalog/microkit/metric"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+var (
+	ClosedError = errors.New("pubsub is closed")
+)
+
+// Message
+// is delivered to handlers of the topic it is published to
+type Message struct {
+	Topic   string
+	Payload []byte
+}
+
+// Handler
+// processes messages of a subscription one by one in the order they are received,
+// returned errors and panics are logged and counted, there is no redelivery
+type Handler func(ctx context.Context, msg Message) error
+
+// PubSub
+// messages are only delivered to subscribers which are subscribed when they are published
+type PubSub interface {
+	// Publish
+	// to send msg to all current subscribers of topic
+	Publish(ctx context.Context, topic string, msg []byte) error
+	// Subscribe
+	// to run handler for messages of topic until ctx is canceled or the subscription is closed,
+	// messages which are published after Subscribe returns are delivered
+	Subscribe(ctx context.Context, topic string, handler Handler) (Subscription, error)
+	// Close
+	// to close all subscriptions and wait for their running handlers
+	Close() error
+}
+
+// Subscription
+// Close stops delivering messages, a handler which is running at that moment is not interrupted
+type Subscription interface {
+	Close() error
+}
+
+type config struct {
+	metric         metric.Metric
+	logger         logger.Logger
+	minBackoff     time.Duration
+	maxBackoff     time.Duration
+	healthInterval time.Duration
+	bufferSize     int
+}
+
+type Option func(*config)
+
+// WithMetric
+// counts published and handled messages of each topic, their errors and handler panics.
+// totals, response times and errors are all labeled by ("pubsub", op, topic), ops are publish, handle,
+// subscribe, receive, reconnect and panic
+func WithMetric(metric metric.Metric) Option {
+	return func(c *config) {
+		c.metric = metric
+	}
+}
+
+// WithLogger
+// logs handler errors, panics and lost connections
+func WithLogger(logger logger.Logger) Option {
+	return func(c *config) {
+		c.logger = logger
+	}
+}
+
+// WithReconnectBackoff
+// the first reconnection waits for min, each failed one doubles the wait up to max
+func WithReconnectBackoff(min time.Duration, max time.Duration) Option {
+	return func(c *config) {
+		c.minBackoff = min
+		c.maxBackoff = max
+	}
+}
+
+// WithHealthCheckInterval
+// redis subscriptions which receive nothing in the interval are pinged, so a broken connection is noticed
+func WithHealthCheckInterval(interval time.Duration) Option {
+	return func(c *config) {
+		c.healthInterval = interval
+	}
+}
+
+// WithBufferSize
+// number of messages which are queued for each in-memory subscription before Publish blocks
+func WithBufferSize(size int) Option {
+	return func(c *config) {
+		c.bufferSize = size
+	}
+}
+
+func newConfig(options []Option) (config, error) {
+	conf := config{
+		metric:         metric.NewNop(),
+		logger:         zap.NopLogger,
+		minBackoff:     time.Millisecond * 100,
+		maxBackoff:     time.Second * 5,
+		healthInterval: time.Second * 30,
+		bufferSize:     100,
+	}
+	for _, op := range options {
+		op(&conf)
+	}
+
+	if conf.minBackoff <= 0 || conf.maxBackoff < conf.minBackoff {
+		return config{}, fmt.Errorf("invalid reconnect backoff %v..%v", conf.minBackoff, conf.maxBackoff)
+	}
+	if conf.healthInterval <= 0 {
+		return config{}, fmt.Errorf("health check interval must be positive, got %v", conf.healthInterval)
+	}
+	if conf.bufferSize < 0 {
+		return config{}, fmt.Errorf("buffer size can not be negative, got %d", conf.bufferSize)
+	}
+
+	return conf, nil
+}
+
+// dispatch
+// runs handler for msg, a panic of handler is recovered so it doesn't stop the subscription
+func (c *config) dispatch(ctx context.Context, handler Handler, msg Message) {
+	c.metric.IncrementTotal("pubsub", "handle", msg.Topic)
+	defer func(startTime time.Time) {
+		c.metric.ObserveResponseTime(time.Since(startTime), "pubsub", "handle", msg.Topic)
+		if p := recover(); p != nil {
+			c.metric.IncrementError("pubsub", "panic", msg.Topic)
+			c.logger.Error("pubsub handler panicked",
+				keyval.String("topic", msg.Topic),
+				keyval.String("panic", fmt.Sprint(p)),
+				keyval.String("stack", string(debug.Stack())),
+			)
+		}
+	}(time.Now())
+
+	err := handler(ctx, msg)
+	if err != nil {
+		c.metric.IncrementError("pubsub", "handle", msg.Topic)
+		c.logger.Warn("pubsub handler failed", keyval.String("topic", msg.Topic), keyval.Error(err))
+	}
+}
+
+// observePublish records a publish which is started at startTime
+func (c *config) observePublish(topic string, startTime time.Time, err error) {
+	c.metric.IncrementTotal("pubsub", "publish", topic)
+	c.metric.ObserveResponseTime(time.Since(startTime), "pubsub", "publish", topic)
+	if err != nil {
+		c.metric.IncrementError("pubsub", "publish", topic)
+	}
+}
+
+// subscription
+// cancel stops the receive loop of the subscription
+type subscription struct {
+	cancel context.CancelFunc
+}
+
+func (s *subscription) Close() error {
+	s.cancel()
+	return nil
+}
+
+// registry
+// keeps subscriptions of a pubsub, so Close is able to stop all of them and wait for their handlers
+type registry struct {
+	lock   sync.Mutex
+	subs   map[*subscription]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+func newRegistry() *registry {
+	return &registry{subs: make(map[*subscription]struct{})}
+}
+
+// add registers a subscription whose receive loop runs until the returned context is done,
+// the loop must call remove when it returns
+func (r *registry) add(ctx context.Context) (*subscription, context.Context, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.closed {
+		return nil, nil, ClosedError
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	s := &subscription{cancel: cancel}
+	r.subs[s] = struct{}{}
+	r.wg.Add(1)
+
+	return s, ctx, nil
+}
+
+func (r *registry) remove(s *subscription) {
+	r.lock.Lock()
+	delete(r.subs, s)
+	r.lock.Unlock()
+
+	s.cancel()
+	r.wg.Done()
+}
+
+func (r *registry) isClosed() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.closed
+}
+
+func (r *registry) close() {
+	r.lock.Lock()
+	r.closed = true
+	for s := range r.subs {
+		s.cancel()
+	}
+	r.lock.Unlock()
+
+	r.wg.Wait()
+}
+
+// sleep waits for d, it returns false when ctx is done earlier
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,200 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"github.com/Electronic-Catalog/microkit/cache"
+	"github.com/Electronic-Catalog/microkit/metric/metrictest"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+// collector keeps payloads which are received by its handler
+type collector struct {
+	lock     sync.Mutex
+	payloads []string
+}
+
+func (c *collector) handle(ctx context.Context, msg Message) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.payloads = append(c.payloads, string(msg.Payload))
+	return nil
+}
+
+func (c *collector) received() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]string(nil), c.payloads...)
+}
+
+func TestPubSub(t *testing.T) {
+	srv := miniredis.RunT(t)
+	rd, err := cache.NewRedisCache(cache.WithAddresses(nil, srv.Addr()))
+	require.NoError(t, err)
+
+	backends := map[string]func(options ...Option) (PubSub, error){
+		"redis": func(options ...Option) (PubSub, error) {
+			return NewRedisPubSub(rd, options...)
+		},
+		"mem": NewInMemoryPubSub,
+	}
+
+	for name, newPubSub := range backends {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			m := metrictest.NewRecorder()
+			ps, err := newPubSub(WithMetric(m))
+			require.NoError(t, err)
+
+			var products, brands collector
+			_, err = ps.Subscribe(ctx, "catalog:product", products.handle)
+			require.NoError(t, err)
+			brandSub, err := ps.Subscribe(ctx, "catalog:brand", brands.handle)
+			require.NoError(t, err)
+
+			require.NoError(t, ps.Publish(ctx, "catalog:product", []byte("1")))
+			require.NoError(t, ps.Publish(ctx, "catalog:product", []byte("2")))
+			require.NoError(t, ps.Publish(ctx, "catalog:brand", []byte("7")))
+
+			require.Eventually(t, func() bool {
+				return len(products.received()) == 2 && len(brands.received()) == 1
+			}, time.Second, time.Millisecond*10)
+			require.Equal(t, []string{"1", "2"}, products.received())
+			require.Equal(t, []string{"7"}, brands.received())
+			require.Equal(t, 2, m.Total("pubsub", "publish", "catalog:product"))
+			require.Equal(t, 2, m.Total("pubsub", "handle", "catalog:product"))
+
+			// a closed subscription receives nothing
+			require.NoError(t, brandSub.Close())
+			require.Eventually(t, func() bool {
+				require.NoError(t, ps.Publish(ctx, "catalog:product", []byte("3")))
+				return len(products.received()) >= 3
+			}, time.Second, time.Millisecond*10)
+			require.NoError(t, ps.Publish(ctx, "catalog:brand", []byte("8")))
+			time.Sleep(time.Millisecond * 50)
+			require.Equal(t, []string{"7"}, brands.received())
+
+			require.NoError(t, ps.Close())
+			require.ErrorIs(t, ps.Publish(ctx, "catalog:product", []byte("4")), ClosedError)
+			_, err = ps.Subscribe(ctx, "catalog:product", products.handle)
+			require.ErrorIs(t, err, ClosedError)
+		})
+	}
+}
+
+func TestHandlerPanicAndError(t *testing.T) {
+	ctx := context.Background()
+	m := metrictest.NewRecorder()
+	ps, err := NewInMemoryPubSub(WithMetric(m))
+	require.NoError(t, err)
+	defer ps.Close()
+
+	var c collector
+	_, err = ps.Subscribe(ctx, "topic", func(ctx context.Context, msg Message) error {
+		switch string(msg.Payload) {
+		case "panic":
+			panic("boom")
+		case "error":
+			return errors.New("failed")
+		}
+		return c.handle(ctx, msg)
+	})
+	require.NoError(t, err)
+
+	for _, payload := range []string{"panic", "error", "ok"} {
+		require.NoError(t, ps.Publish(ctx, "topic", []byte(payload)))
+	}
+
+	// the subscription survives a panicking handler
+	require.Eventually(t, func() bool {
+		return len(c.received()) == 1
+	}, time.Second, time.Millisecond*10)
+	require.Equal(t, 1, m.Error("pubsub", "panic", "topic"))
+	require.Equal(t, 1, m.Error("pubsub", "handle", "topic"))
+}
+
+func TestSubscribeContext(t *testing.T) {
+	ps, err := NewInMemoryPubSub()
+	require.NoError(t, err)
+	defer ps.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var c collector
+	_, err = ps.Subscribe(ctx, "topic", c.handle)
+	require.NoError(t, err)
+	cancel()
+
+	// the subscription is removed once its context is canceled
+	require.Eventually(t, func() bool {
+		mp := ps.(*memPubSub)
+		mp.lock.RLock()
+		defer mp.lock.RUnlock()
+		return len(mp.subs) == 0
+	}, time.Second, time.Millisecond*10)
+	require.NoError(t, ps.Publish(context.Background(), "topic", []byte("1")))
+	require.Empty(t, c.received())
+}
+
+func TestCloseWaitsForHandlers(t *testing.T) {
+	ctx := context.Background()
+	ps, err := NewInMemoryPubSub()
+	require.NoError(t, err)
+
+	started := make(chan struct{})
+	finished := false
+	_, err = ps.Subscribe(ctx, "topic", func(ctx context.Context, msg Message) error {
+		close(started)
+		time.Sleep(time.Millisecond * 50)
+		finished = true
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, ps.Publish(ctx, "topic", []byte("1")))
+
+	<-started
+	require.NoError(t, ps.Close())
+	require.True(t, finished)
+}
+
+func TestRedisReconnect(t *testing.T) {
+	srv := miniredis.RunT(t)
+	rd, err := cache.NewRedisCache(cache.WithAddresses(nil, srv.Addr()))
+	require.NoError(t, err)
+
+	m := metrictest.NewRecorder()
+	ps, err := NewRedisPubSub(rd, WithMetric(m), WithReconnectBackoff(time.Millisecond*10, time.Millisecond*50))
+	require.NoError(t, err)
+	defer ps.Close()
+
+	ctx := context.Background()
+	var c collector
+	_, err = ps.Subscribe(ctx, "topic", c.handle)
+	require.NoError(t, err)
+
+	srv.Close()
+	require.NoError(t, srv.Restart())
+
+	// the subscription is renewed on the restarted server
+	require.Eventually(t, func() bool {
+		err := ps.Publish(ctx, "topic", []byte("after restart"))
+		return err == nil && len(c.received()) > 0
+	}, time.Second*5, time.Millisecond*50)
+	require.Positive(t, m.Total("pubsub", "reconnect", "topic"))
+	require.Positive(t, m.Error("pubsub", "receive", "topic"))
+}
+
+func TestNewRedisPubSubNeedsRedis(t *testing.T) {
+	mem, err := cache.NewInMemoryCache(time.Minute)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	_, err = NewRedisPubSub(mem)
+	require.Error(t, err)
+
+	_, err = NewInMemoryPubSub(WithReconnectBackoff(time.Second, time.Millisecond))
+	require.Error(t, err)
+}
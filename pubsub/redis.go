@@ -0,0 +1,135 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"github.com/Electronic-Catalog/microkit/cache"
+	"github.com/Electronic-Catalog/microkit/logger/keyval"
+	"github.com/go-redis/redis/v8"
+	"net"
+	"time"
+)
+
+type redisPubSub struct {
+	client   redis.UniversalClient
+	conf     config
+	registry *registry
+}
+
+// NewRedisPubSub
+// publishes and subscribes on redis pub/sub over the client of c, which may be a redis cache wrapped by middlewares.
+// lost connections are reconnected with backoff and subscriptions are renewed, messages which are published
+// while a subscriber is disconnected are lost like any other redis pub/sub message.
+// Close doesn't close c
+func NewRedisPubSub(c cache.Cache, options ...Option) (PubSub, error) {
+	client, err := cache.RedisClient(c)
+	if err != nil {
+		return nil, err
+	}
+
+	conf, err := newConfig(options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &redisPubSub{
+		client:   client,
+		conf:     conf,
+		registry: newRegistry(),
+	}, nil
+}
+
+func (r *redisPubSub) Publish(ctx context.Context, topic string, msg []byte) error {
+	if r.registry.isClosed() {
+		return ClosedError
+	}
+
+	startTime := time.Now()
+	err := r.client.Publish(ctx, topic, msg).Err()
+	r.conf.observePublish(topic, startTime, err)
+
+	return err
+}
+
+func (r *redisPubSub) Subscribe(ctx context.Context, topic string, handler Handler) (Subscription, error) {
+	ps := r.client.Subscribe(ctx, topic)
+	// wait for subscription confirmation, otherwise messages which are published right after Subscribe would be lost
+	_, err := ps.Receive(ctx)
+	if err != nil {
+		_ = ps.Close()
+		r.conf.metric.IncrementError("pubsub", "subscribe", topic)
+		return nil, err
+	}
+
+	s, ctx, err := r.registry.add(ctx)
+	if err != nil {
+		_ = ps.Close()
+		return nil, err
+	}
+
+	go r.receive(ctx, s, ps, topic, handler)
+
+	return s, nil
+}
+
+func (r *redisPubSub) Close() error {
+	r.registry.close()
+	return nil
+}
+
+// receive
+// delivers messages of ps to handler until ctx is done, go-redis renews the connection and its subscriptions
+// on the next receive after a network error, so failures only need a backoff here
+func (r *redisPubSub) receive(ctx context.Context, s *subscription, ps *redis.PubSub, topic string, handler Handler) {
+	defer r.registry.remove(s)
+	// reads of go-redis don't watch the context, closing ps unblocks them
+	stop := context.AfterFunc(ctx, func() {
+		_ = ps.Close()
+	})
+	defer stop()
+	defer ps.Close()
+
+	backoff := r.conf.minBackoff
+	connected := true
+	for {
+		msg, err := ps.ReceiveTimeout(ctx, r.conf.healthInterval)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				// nothing is received in the interval, a ping tells whether the connection is still alive
+				err = ps.Ping(ctx)
+				if err == nil {
+					continue
+				}
+			}
+
+			r.conf.metric.IncrementError("pubsub", "receive", topic)
+			if connected {
+				r.conf.logger.Warn("pubsub connection lost, reconnecting",
+					keyval.String("topic", topic), keyval.Error(err))
+				connected = false
+			}
+			if !sleep(ctx, backoff) {
+				return
+			}
+			backoff = min(backoff*2, r.conf.maxBackoff)
+			continue
+		}
+
+		switch m := msg.(type) {
+		case *redis.Subscription:
+			if !connected {
+				r.conf.metric.IncrementTotal("pubsub", "reconnect", topic)
+				r.conf.logger.Info("pubsub reconnected", keyval.String("topic", topic))
+				connected = true
+				backoff = r.conf.minBackoff
+			}
+		case *redis.Message:
+			r.conf.dispatch(ctx, handler, Message{Topic: m.Channel, Payload: []byte(m.Payload)})
+		}
+	}
+}
@@ -0,0 +1,216 @@
+// Package queue provides a durable job queue on redis streams, jobs survive restarts of workers
+// and jobs of crashed workers are taken over by the others.
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Electronic-Catalog/microkit/logger"
+	"github.com/Electronic-Catalog/microkit/logger/zap"
+	"github.com/Electronic-Catalog/microkit/metric"
+	"os"
+	"strconv"
+	"time"
+)
+
+var (
+	// ReclaimedError is the failure of a job which is not acked in the visibility timeout, e.g. its worker crashed
+	ReclaimedError = errors.New("job is not acked in visibility timeout")
+)
+
+// Job
+// a unit of work which is enqueued by Enqueue and given to the handler of Consume
+type Job struct {
+	// ID of the stream entry, it changes when the job is retried
+	ID      string
+	Queue   string
+	Payload []byte
+	// Attempt is 1 on the first delivery and grows with each retry
+	Attempt    int
+	EnqueuedAt time.Time
+	// LastError is only set for jobs of the dead-letter stream
+	LastError string
+}
+
+// Handler
+// processes a job, the job is acked when it returns nil, otherwise it is retried with backoff
+// or moved to the dead-letter stream after max attempts. its context is canceled after visibility timeout,
+// because the job is handed to another worker then
+type Handler func(ctx context.Context, job Job) error
+
+// Queue
+// delivers each job to one of the workers of the consumer group, jobs are delivered at least once,
+// so handlers have to be idempotent
+type Queue interface {
+	// Enqueue
+	// to add a job and return its id
+	Enqueue(ctx context.Context, payload []byte) (string, error)
+	// Consume
+	// to run handler for jobs until ctx is canceled, running handlers are allowed to finish then.
+	// it returns nil after ctx is canceled and an error when the consumer group can not be created
+	Consume(ctx context.Context, handler Handler) error
+	// DeadLetters
+	// to read the oldest count jobs which are failed max attempts times
+	DeadLetters(ctx context.Context, count int64) ([]Job, error)
+}
+
+type config struct {
+	prefix            string
+	group             string
+	consumer          string
+	concurrency       int
+	batchSize         int64
+	maxAttempts       int
+	minBackoff        time.Duration
+	maxBackoff        time.Duration
+	visibilityTimeout time.Duration
+	pollInterval      time.Duration
+	metric            metric.Metric
+	logger            logger.Logger
+	now               func() time.Time
+}
+
+type Option func(*config)
+
+// WithPrefix
+// is prepended to redis keys of the queue, "queue:" by default
+func WithPrefix(prefix string) Option {
+	return func(c *config) {
+		c.prefix = prefix
+	}
+}
+
+// WithGroup
+// name of the consumer group, each group receives every job once, "workers" by default
+func WithGroup(group string) Option {
+	return func(c *config) {
+		c.group = group
+	}
+}
+
+// WithConsumer
+// name of this worker in the consumer group, it has to be unique between workers,
+// hostname and process id are used by default
+func WithConsumer(consumer string) Option {
+	return func(c *config) {
+		c.consumer = consumer
+	}
+}
+
+// WithConcurrency
+// number of jobs which are handled at once by Consume, 1 by default
+func WithConcurrency(concurrency int) Option {
+	return func(c *config) {
+		c.concurrency = concurrency
+	}
+}
+
+// WithBatchSize
+// number of due retries and reclaimed jobs which are moved by each call to redis, 10 by default,
+// new jobs are read one at a time by each worker so they don't wait for each other in visibility timeout
+func WithBatchSize(size int64) Option {
+	return func(c *config) {
+		c.batchSize = size
+	}
+}
+
+// WithMaxAttempts
+// a job which fails max attempts times (5 by default) is moved to the dead-letter stream
+func WithMaxAttempts(attempts int) Option {
+	return func(c *config) {
+		c.maxAttempts = attempts
+	}
+}
+
+// WithBackoff
+// the first retry waits for min (1s by default), each next one doubles the wait up to max (5m by default)
+func WithBackoff(min time.Duration, max time.Duration) Option {
+	return func(c *config) {
+		c.minBackoff = min
+		c.maxBackoff = max
+	}
+}
+
+// WithVisibilityTimeout
+// a job which is not acked in the timeout (30s by default) is reclaimed by another worker
+// and counted as a failed attempt
+func WithVisibilityTimeout(timeout time.Duration) Option {
+	return func(c *config) {
+		c.visibilityTimeout = timeout
+	}
+}
+
+// WithPollInterval
+// idle workers wait for new jobs at most for the interval (2s by default) before they look for
+// retries which are due and jobs to reclaim, it bounds the time Consume needs to return after ctx is canceled
+func WithPollInterval(interval time.Duration) Option {
+	return func(c *config) {
+		c.pollInterval = interval
+	}
+}
+
+// WithMetric
+// counts enqueued, acked, retried, reclaimed and dead jobs of each queue and handler errors
+func WithMetric(metric metric.Metric) Option {
+	return func(c *config) {
+		c.metric = metric
+	}
+}
+
+// WithLogger
+// logs failed, reclaimed and dead jobs
+func WithLogger(logger logger.Logger) Option {
+	return func(c *config) {
+		c.logger = logger
+	}
+}
+
+func newConfig(options []Option) (config, error) {
+	hostname, _ := os.Hostname()
+	conf := config{
+		prefix:            "queue:",
+		group:             "workers",
+		consumer:          hostname + "-" + strconv.Itoa(os.Getpid()),
+		concurrency:       1,
+		batchSize:         10,
+		maxAttempts:       5,
+		minBackoff:        time.Second,
+		maxBackoff:        time.Minute * 5,
+		visibilityTimeout: time.Second * 30,
+		pollInterval:      time.Second * 2,
+		metric:            metric.NewNop(),
+		logger:            zap.NopLogger,
+		now:               time.Now,
+	}
+	for _, op := range options {
+		op(&conf)
+	}
+
+	switch {
+	case conf.group == "" || conf.consumer == "":
+		return config{}, errors.New("consumer group and consumer name can not be empty")
+	case conf.concurrency < 1:
+		return config{}, fmt.Errorf("concurrency must be at least 1, got %d", conf.concurrency)
+	case conf.batchSize < 1:
+		return config{}, fmt.Errorf("batch size must be at least 1, got %d", conf.batchSize)
+	case conf.maxAttempts < 1:
+		return config{}, fmt.Errorf("max attempts must be at least 1, got %d", conf.maxAttempts)
+	case conf.minBackoff <= 0 || conf.maxBackoff < conf.minBackoff:
+		return config{}, fmt.Errorf("invalid backoff %v..%v", conf.minBackoff, conf.maxBackoff)
+	case conf.visibilityTimeout <= 0 || conf.pollInterval <= 0:
+		return config{}, errors.New("visibility timeout and poll interval must be positive")
+	}
+
+	return conf, nil
+}
+
+// backoff returns the wait before the retry which follows attempt
+func (c *config) backoff(attempt int) time.Duration {
+	wait := c.minBackoff
+	for i := 1; i < attempt && wait < c.maxBackoff; i++ {
+		wait *= 2
+	}
+
+	return min(wait, c.maxBackoff)
+}
@@ -0,0 +1,232 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Electronic-Catalog/microkit/cache"
+	"github.com/Electronic-Catalog/microkit/metric/metrictest"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestQueue(t *testing.T, options ...Option) (Queue, *miniredis.Miniredis) {
+	srv := miniredis.RunT(t)
+	rd, err := cache.NewRedisCache(cache.WithAddresses(nil, srv.Addr()))
+	require.NoError(t, err)
+
+	options = append([]Option{
+		WithBackoff(time.Millisecond*10, time.Millisecond*40),
+		WithPollInterval(time.Millisecond * 20),
+	}, options...)
+	q, err := NewRedisQueue(rd, "images", options...)
+	require.NoError(t, err)
+
+	return q, srv
+}
+
+// consume runs Consume in background until the test ends
+func consume(t *testing.T, q Queue, handler Handler) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- q.Consume(ctx, handler)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		require.NoError(t, <-done)
+	})
+}
+
+func TestEnqueueAndAck(t *testing.T) {
+	m := metrictest.NewRecorder()
+	q, srv := newTestQueue(t, WithMetric(m), WithConcurrency(2))
+	ctx := context.Background()
+
+	lock := &sync.Mutex{}
+	handled := map[string]int{}
+	consume(t, q, func(ctx context.Context, job Job) error {
+		lock.Lock()
+		defer lock.Unlock()
+		handled[string(job.Payload)] = job.Attempt
+		assert.Equal(t, "images", job.Queue)
+		return nil
+	})
+
+	for _, payload := range []string{"a.png", "b.png", "c.png"} {
+		_, err := q.Enqueue(ctx, []byte(payload))
+		require.NoError(t, err)
+	}
+
+	require.Eventually(t, func() bool {
+		return m.Total("queue", "images", "ack") == 3
+	}, time.Second*2, time.Millisecond*10)
+	require.Equal(t, map[string]int{"a.png": 1, "b.png": 1, "c.png": 1}, handled)
+	require.Equal(t, 3, m.Total("queue", "images", "enqueue"))
+
+	// acked jobs are removed from the stream
+	entries, err := srv.Stream("queue:{images}")
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestRetryWithBackoff(t *testing.T) {
+	m := metrictest.NewRecorder()
+	q, _ := newTestQueue(t, WithMetric(m))
+	ctx := context.Background()
+
+	lock := &sync.Mutex{}
+	var attempts []int
+	var enqueuedAt []time.Time
+	consume(t, q, func(ctx context.Context, job Job) error {
+		lock.Lock()
+		defer lock.Unlock()
+		attempts = append(attempts, job.Attempt)
+		enqueuedAt = append(enqueuedAt, job.EnqueuedAt)
+		if job.Attempt == 2 {
+			panic("corrupted image")
+		}
+		if job.Attempt < 3 {
+			return errors.New("image service is unavailable")
+		}
+		return nil
+	})
+
+	_, err := q.Enqueue(ctx, []byte("a:b.png"))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return m.Total("queue", "images", "ack") == 1
+	}, time.Second*2, time.Millisecond*10)
+	require.Equal(t, []int{1, 2, 3}, attempts)
+	require.Equal(t, enqueuedAt[0], enqueuedAt[2])
+	require.Equal(t, 2, m.Total("queue", "images", "retry"))
+	require.Equal(t, 2, m.Error("queue", "images", "handler"))
+	require.Equal(t, 1, m.Error("queue", "images", "panic"))
+}
+
+func TestDeadLetter(t *testing.T) {
+	m := metrictest.NewRecorder()
+	q, _ := newTestQueue(t, WithMetric(m), WithMaxAttempts(2))
+	ctx := context.Background()
+
+	consume(t, q, func(ctx context.Context, job Job) error {
+		return errors.New("unsupported format")
+	})
+
+	_, err := q.Enqueue(ctx, []byte("a.tiff"))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return m.Total("queue", "images", "dead") == 1
+	}, time.Second*2, time.Millisecond*10)
+
+	dead, err := q.DeadLetters(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, dead, 1)
+	require.Equal(t, "a.tiff", string(dead[0].Payload))
+	require.Equal(t, 2, dead[0].Attempt)
+	require.Equal(t, "unsupported format", dead[0].LastError)
+}
+
+func TestReclaim(t *testing.T) {
+	m := metrictest.NewRecorder()
+	q, srv := newTestQueue(t, WithMetric(m), WithVisibilityTimeout(time.Millisecond*50))
+	ctx := context.Background()
+	rq := q.(*redisQueue)
+
+	// a worker reads the job and crashes before acking it
+	require.NoError(t, rq.client.XGroupCreateMkStream(ctx, rq.stream, "workers", "0").Err())
+	_, err := q.Enqueue(ctx, []byte("a.png"))
+	require.NoError(t, err)
+	client := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	defer client.Close()
+	_, err = client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    "workers",
+		Consumer: "crashed",
+		Streams:  []string{rq.stream, ">"},
+		Count:    1,
+	}).Result()
+	require.NoError(t, err)
+
+	handled := make(chan Job, 1)
+	consume(t, q, func(ctx context.Context, job Job) error {
+		handled <- job
+		return nil
+	})
+
+	// the reclaimed job counts as a failed attempt
+	select {
+	case job := <-handled:
+		require.Equal(t, "a.png", string(job.Payload))
+		require.Equal(t, 2, job.Attempt)
+	case <-time.After(time.Second * 2):
+		t.Fatal("job is not reclaimed")
+	}
+	require.Equal(t, 1, m.Total("queue", "images", "reclaim"))
+}
+
+func TestSlowHandlerOfBatch(t *testing.T) {
+	m := metrictest.NewRecorder()
+	q, _ := newTestQueue(t, WithMetric(m), WithVisibilityTimeout(time.Millisecond*100),
+		WithBatchSize(5), WithConcurrency(2))
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		_, err := q.Enqueue(ctx, []byte("a.png"))
+		require.NoError(t, err)
+	}
+
+	// jobs of a batch wait for each other longer than visibility timeout, they must not be reclaimed meanwhile
+	var handled atomic.Int32
+	consume(t, q, func(ctx context.Context, job Job) error {
+		time.Sleep(time.Millisecond * 60)
+		handled.Add(1)
+		if job.Attempt != 1 {
+			return fmt.Errorf("job is handled again")
+		}
+		return nil
+	})
+
+	require.Eventually(t, func() bool {
+		return m.Total("queue", "images", "ack") == 5
+	}, time.Second*2, time.Millisecond*10)
+	require.Equal(t, int32(5), handled.Load())
+	require.Equal(t, 0, m.Total("queue", "images", "reclaim"))
+}
+
+func TestInvalidQueue(t *testing.T) {
+	srv := miniredis.RunT(t)
+	rd, err := cache.NewRedisCache(cache.WithAddresses(nil, srv.Addr()))
+	require.NoError(t, err)
+
+	_, err = NewRedisQueue(rd, "")
+	require.Error(t, err)
+	_, err = NewRedisQueue(rd, "images", WithMaxAttempts(0))
+	require.Error(t, err)
+	_, err = NewRedisQueue(rd, "images", WithBackoff(time.Second, time.Millisecond))
+	require.Error(t, err)
+
+	mem, err := cache.NewInMemoryCache(time.Minute)
+	require.NoError(t, err)
+	defer mem.Close()
+	_, err = NewRedisQueue(mem, "images")
+	require.Error(t, err)
+}
+
+func TestBackoff(t *testing.T) {
+	conf, err := newConfig([]Option{WithBackoff(time.Second, time.Second*5)})
+	require.NoError(t, err)
+
+	require.Equal(t, time.Second, conf.backoff(1))
+	require.Equal(t, time.Second*2, conf.backoff(2))
+	require.Equal(t, time.Second*4, conf.backoff(3))
+	require.Equal(t, time.Second*5, conf.backoff(4))
+	require.Equal(t, time.Second*5, conf.backoff(40))
+}
@@ -0,0 +1,375 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"github.com/Electronic-Catalog/microkit/cache"
+	"github.com/Electronic-Catalog/microkit/logger/keyval"
+	"github.com/go-redis/redis/v8"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// keys of a queue share a hash tag, so scripts are able to touch all of them in cluster mode
+
+// promoteScript
+// moves retries which are due from the delayed set back to the stream,
+// members are "<attempt>:<enqueued at>:<previous id>:<payload>"
+// KEYS[1] stream, KEYS[2] delayed set, ARGV now in milliseconds, max number of moved jobs
+var promoteScript = redis.NewScript(`
+local due = redis.call("ZRANGEBYSCORE", KEYS[2], "-inf", ARGV[1], "LIMIT", 0, tonumber(ARGV[2]))
+for _, member in ipairs(due) do
+	local a = string.find(member, ":", 1, true)
+	local b = string.find(member, ":", a + 1, true)
+	local c = string.find(member, ":", b + 1, true)
+	redis.call("XADD", KEYS[1], "*",
+		"payload", string.sub(member, c + 1),
+		"attempt", string.sub(member, 1, a - 1),
+		"enqueued_at", string.sub(member, a + 1, b - 1))
+	redis.call("ZREM", KEYS[2], member)
+end
+return #due
+`)
+
+// retryScript
+// acks a failed job and schedules its retry
+// KEYS[1] stream, KEYS[2] delayed set, ARGV group, id, time of retry in milliseconds, member of delayed set
+var retryScript = redis.NewScript(`
+redis.call("ZADD", KEYS[2], ARGV[3], ARGV[4])
+redis.call("XACK", KEYS[1], ARGV[1], ARGV[2])
+redis.call("XDEL", KEYS[1], ARGV[2])
+return 1
+`)
+
+// deadScript
+// acks a job which failed max attempts times and appends it to the dead-letter stream
+// KEYS[1] stream, KEYS[2] dead-letter stream, ARGV group, id, payload, attempt, enqueued at, error
+var deadScript = redis.NewScript(`
+redis.call("XADD", KEYS[2], "*",
+	"payload", ARGV[3], "attempt", ARGV[4], "enqueued_at", ARGV[5], "error", ARGV[6], "id", ARGV[2])
+redis.call("XACK", KEYS[1], ARGV[1], ARGV[2])
+redis.call("XDEL", KEYS[1], ARGV[2])
+return 1
+`)
+
+type redisQueue struct {
+	client  redis.UniversalClient
+	name    string
+	stream  string
+	delayed string
+	dead    string
+	conf    config
+}
+
+// NewRedisQueue
+// keeps jobs of queue name in a redis stream of the client of c, keys of a queue share a hash tag for cluster mode.
+// failed jobs wait for their retry in a sorted set and jobs which failed max attempts times are moved
+// to a dead-letter stream, acked jobs are removed from the stream
+func NewRedisQueue(c cache.Cache, name string, options ...Option) (Queue, error) {
+	if name == "" {
+		return nil, fmt.Errorf("queue name can not be empty")
+	}
+
+	client, err := cache.RedisClient(c)
+	if err != nil {
+		return nil, err
+	}
+
+	conf, err := newConfig(options)
+	if err != nil {
+		return nil, err
+	}
+
+	stream := conf.prefix + "{" + name + "}"
+	return &redisQueue{
+		client:  client,
+		name:    name,
+		stream:  stream,
+		delayed: stream + ":delayed",
+		dead:    stream + ":dead",
+		conf:    conf,
+	}, nil
+}
+
+func (q *redisQueue) Enqueue(ctx context.Context, payload []byte) (string, error) {
+	q.conf.metric.IncrementTotal("queue", q.name, "enqueue")
+	defer func(startTime time.Time) {
+		q.conf.metric.ObserveResponseTime(time.Since(startTime), "queue", q.name, "enqueue")
+	}(time.Now())
+
+	id, err := q.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: q.stream,
+		Values: []interface{}{
+			"payload", payload,
+			"attempt", 1,
+			"enqueued_at", q.conf.now().UnixMilli(),
+		},
+	}).Result()
+	if err != nil {
+		q.conf.metric.IncrementError("queue", q.name, "enqueue")
+		return "", err
+	}
+
+	return id, nil
+}
+
+func (q *redisQueue) Consume(ctx context.Context, handler Handler) error {
+	err := q.client.XGroupCreateMkStream(ctx, q.stream, q.conf.group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < q.conf.concurrency; i++ {
+		consumer := q.conf.consumer
+		if q.conf.concurrency > 1 {
+			consumer += "-" + strconv.Itoa(i)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx, consumer, handler)
+		}()
+	}
+	wg.Wait()
+
+	return nil
+}
+
+func (q *redisQueue) DeadLetters(ctx context.Context, count int64) ([]Job, error) {
+	msgs, err := q.client.XRangeN(ctx, q.dead, "-", "+", count).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]Job, len(msgs))
+	for i, msg := range msgs {
+		jobs[i] = q.job(msg.ID, msg.Values)
+	}
+
+	return jobs, nil
+}
+
+// work
+// handles jobs as consumer until ctx is canceled, failures of redis are retried after poll interval
+func (q *redisQueue) work(ctx context.Context, consumer string, handler Handler) {
+	cursor := "0-0"
+	for ctx.Err() == nil {
+		jobs, reclaimed, err := q.fetch(ctx, consumer, &cursor)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			q.conf.metric.IncrementError("queue", q.name, "consume")
+			q.conf.logger.Warn("queue could not read jobs", keyval.String("queue", q.name), keyval.Error(err))
+			sleep(ctx, q.conf.pollInterval)
+			continue
+		}
+
+		// jobs are finished even when ctx is canceled meanwhile, otherwise they would wait for visibility timeout
+		opCtx := context.WithoutCancel(ctx)
+		for _, job := range reclaimed {
+			q.conf.metric.IncrementTotal("queue", q.name, "reclaim")
+			q.conf.logger.Warn("queue reclaimed a job which is not acked in visibility timeout",
+				keyval.String("queue", q.name), keyval.String("id", job.ID), keyval.Int("attempt", job.Attempt))
+			q.fail(opCtx, job, ReclaimedError)
+		}
+		for _, job := range jobs {
+			q.handle(opCtx, handler, job)
+		}
+	}
+}
+
+// fetch
+// moves due retries to the stream, then claims jobs which are not acked in visibility timeout
+// and reads a new job when there is nothing to claim
+func (q *redisQueue) fetch(ctx context.Context, consumer string, cursor *string) ([]Job, []Job, error) {
+	err := promoteScript.Run(ctx, q.client, []string{q.stream, q.delayed},
+		q.conf.now().UnixMilli(), q.conf.batchSize).Err()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reclaimed, err := q.autoClaim(ctx, consumer, cursor)
+	if err != nil || len(reclaimed) > 0 {
+		return nil, reclaimed, err
+	}
+
+	// a worker handles one job at a time, jobs of a larger batch would spend their visibility timeout
+	// waiting for the previous ones and be reclaimed while they are still pending
+	streams, err := q.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    q.conf.group,
+		Consumer: consumer,
+		Streams:  []string{q.stream, ">"},
+		Count:    1,
+		Block:    q.conf.pollInterval,
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	var jobs []Job
+	for _, stream := range streams {
+		for _, msg := range stream.Messages {
+			jobs = append(jobs, q.job(msg.ID, msg.Values))
+		}
+	}
+
+	return jobs, nil, nil
+}
+
+// autoClaim
+// runs XAUTOCLAIM, its reply is parsed here because go-redis v8 rejects the reply of redis 7
+// which has a third element with ids of deleted entries
+func (q *redisQueue) autoClaim(ctx context.Context, consumer string, cursor *string) ([]Job, error) {
+	reply, err := q.client.Do(ctx, "XAUTOCLAIM", q.stream, q.conf.group, consumer,
+		q.conf.visibilityTimeout.Milliseconds(), *cursor, "COUNT", q.conf.batchSize).Slice()
+	if err != nil {
+		return nil, err
+	}
+	if len(reply) < 2 {
+		return nil, fmt.Errorf("unexpected XAUTOCLAIM reply of %d elements", len(reply))
+	}
+
+	*cursor, _ = reply[0].(string)
+	entries, _ := reply[1].([]interface{})
+
+	var jobs []Job
+	for _, entry := range entries {
+		// entries which are deleted meanwhile are nil in redis 6.2
+		fields, ok := entry.([]interface{})
+		if !ok || len(fields) != 2 {
+			continue
+		}
+		id, _ := fields[0].(string)
+		pairs, _ := fields[1].([]interface{})
+		values := make(map[string]interface{}, len(pairs)/2)
+		for i := 0; i+1 < len(pairs); i += 2 {
+			key, _ := pairs[i].(string)
+			values[key] = pairs[i+1]
+		}
+		jobs = append(jobs, q.job(id, values))
+	}
+
+	return jobs, nil
+}
+
+// handle runs handler for job and acks or fails it by its result
+func (q *redisQueue) handle(ctx context.Context, handler Handler, job Job) {
+	handlerCtx, cancel := context.WithTimeout(ctx, q.conf.visibilityTimeout)
+	defer cancel()
+
+	startTime := time.Now()
+	err := q.run(handlerCtx, handler, job)
+	q.conf.metric.ObserveResponseTime(time.Since(startTime), "queue", q.name, "handle")
+	if err != nil {
+		q.conf.metric.IncrementError("queue", q.name, "handler")
+		q.fail(ctx, job, err)
+		return
+	}
+
+	_, err = q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAck(ctx, q.stream, q.conf.group, job.ID)
+		pipe.XDel(ctx, q.stream, job.ID)
+		return nil
+	})
+	if err != nil {
+		// the job is reclaimed and handled again after visibility timeout
+		q.conf.metric.IncrementError("queue", q.name, "ack")
+		q.conf.logger.Warn("queue could not ack a job",
+			keyval.String("queue", q.name), keyval.String("id", job.ID), keyval.Error(err))
+		return
+	}
+	q.conf.metric.IncrementTotal("queue", q.name, "ack")
+}
+
+// run calls handler and turns its panic into an error
+func (q *redisQueue) run(ctx context.Context, handler Handler, job Job) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			q.conf.metric.IncrementError("queue", q.name, "panic")
+			err = fmt.Errorf("job handler panicked: %v", p)
+		}
+	}()
+
+	return handler(ctx, job)
+}
+
+// fail
+// schedules retry of job after backoff, or moves it to the dead-letter stream after max attempts
+func (q *redisQueue) fail(ctx context.Context, job Job, cause error) {
+	enqueuedAt := strconv.FormatInt(job.EnqueuedAt.UnixMilli(), 10)
+
+	if job.Attempt >= q.conf.maxAttempts {
+		err := deadScript.Run(ctx, q.client, []string{q.stream, q.dead},
+			q.conf.group, job.ID, job.Payload, job.Attempt, enqueuedAt, cause.Error()).Err()
+		if err != nil {
+			q.conf.metric.IncrementError("queue", q.name, "dead")
+			q.conf.logger.Warn("queue could not move a job to dead-letter stream",
+				keyval.String("queue", q.name), keyval.String("id", job.ID), keyval.Error(err))
+			return
+		}
+		q.conf.metric.IncrementTotal("queue", q.name, "dead")
+		q.conf.logger.Error("queue moved a job to dead-letter stream",
+			keyval.String("queue", q.name), keyval.String("id", job.ID),
+			keyval.Int("attempt", job.Attempt), keyval.Error(cause))
+		return
+	}
+
+	wait := q.conf.backoff(job.Attempt)
+	member := strconv.Itoa(job.Attempt+1) + ":" + enqueuedAt + ":" + job.ID + ":" + string(job.Payload)
+	err := retryScript.Run(ctx, q.client, []string{q.stream, q.delayed},
+		q.conf.group, job.ID, q.conf.now().Add(wait).UnixMilli(), member).Err()
+	if err != nil {
+		q.conf.metric.IncrementError("queue", q.name, "retry")
+		q.conf.logger.Warn("queue could not schedule retry of a job",
+			keyval.String("queue", q.name), keyval.String("id", job.ID), keyval.Error(err))
+		return
+	}
+	q.conf.metric.IncrementTotal("queue", q.name, "retry")
+	q.conf.logger.Warn("queue job failed, it is retried",
+		keyval.String("queue", q.name), keyval.String("id", job.ID), keyval.Int("attempt", job.Attempt),
+		keyval.String("retry_after", wait.String()), keyval.Error(cause))
+}
+
+// job builds a job from fields of a stream entry
+func (q *redisQueue) job(id string, values map[string]interface{}) Job {
+	field := func(name string) string {
+		val, _ := values[name].(string)
+		return val
+	}
+
+	job := Job{
+		ID:        id,
+		Queue:     q.name,
+		Payload:   []byte(field("payload")),
+		LastError: field("error"),
+	}
+	job.Attempt, _ = strconv.Atoi(field("attempt"))
+	if job.Attempt < 1 {
+		job.Attempt = 1
+	}
+	if ms, err := strconv.ParseInt(field("enqueued_at"), 10, 64); err == nil {
+		job.EnqueuedAt = time.UnixMilli(ms)
+	}
+
+	return job
+}
+
+// sleep waits for d, it returns false when ctx is done earlier
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
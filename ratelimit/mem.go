@@ -0,0 +1,207 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/Electronic-Catalog/microkit/metric"
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often idle keys are removed from an in-memory limiter
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens float64
+	ts     time.Time
+}
+
+type window struct {
+	count      int
+	expiration time.Time
+}
+
+type memLimiter struct {
+	algorithm Algorithm
+	limit     Limit
+	prefix    string
+	metric    metric.Metric
+	now       func() time.Time
+	lock      *sync.Mutex
+	buckets   map[string]*bucket
+	windows   map[string]*window
+	logs      map[string][]time.Time
+	lastSweep time.Time
+}
+
+// NewInMemoryLimiter
+// counts requests in memory of the current process, it behaves the same as NewRedisLimiter
+// and is useful for tests and single replica services
+func NewInMemoryLimiter(algorithm Algorithm, limit Limit, options ...Option) (Limiter, error) {
+	err := validate(algorithm, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	conf := newConfig(options)
+
+	return &memLimiter{
+		algorithm: algorithm,
+		limit:     limit,
+		prefix:    conf.prefix,
+		metric:    conf.metric,
+		now:       conf.now,
+		lock:      &sync.Mutex{},
+		buckets:   make(map[string]*bucket),
+		windows:   make(map[string]*window),
+		logs:      make(map[string][]time.Time),
+		lastSweep: conf.now(),
+	}, nil
+}
+
+func (m *memLimiter) Allow(ctx context.Context, key string) (Decision, error) {
+	return m.AllowN(ctx, key, 1)
+}
+
+func (m *memLimiter) AllowN(ctx context.Context, key string, n int) (Decision, error) {
+	if err := m.limit.checkTokens(m.algorithm, n); err != nil {
+		return Decision{}, err
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := m.now()
+	m.sweep(now)
+
+	var d Decision
+	switch m.algorithm {
+	case TokenBucket:
+		d = m.tokenBucket(m.prefix+key, n, now)
+	case FixedWindow:
+		d = m.fixedWindow(m.prefix+key, n, now)
+	case SlidingWindowLog:
+		d = m.slidingWindowLog(m.prefix+key, n, now)
+	}
+	observe(m.metric, m.algorithm, d)
+
+	return d, nil
+}
+
+func (m *memLimiter) Reset(ctx context.Context, key string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.buckets, m.prefix+key)
+	delete(m.windows, m.prefix+key)
+	delete(m.logs, m.prefix+key)
+
+	return nil
+}
+
+func (m *memLimiter) tokenBucket(key string, n int, now time.Time) Decision {
+	capacity := float64(m.limit.max(m.algorithm))
+	// tokens per nanosecond
+	rate := float64(m.limit.Rate) / float64(m.limit.Period)
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, ts: now}
+		m.buckets[key] = b
+	}
+	if now.After(b.ts) {
+		b.tokens = math.Min(capacity, b.tokens+float64(now.Sub(b.ts))*rate)
+		b.ts = now
+	}
+
+	d := Decision{Limit: m.limit.max(m.algorithm)}
+	if b.tokens >= float64(n) {
+		b.tokens -= float64(n)
+		d.Allowed = true
+	} else {
+		d.RetryAfter = roundUp(time.Duration((float64(n) - b.tokens) / rate))
+	}
+	d.Remaining = int(math.Floor(b.tokens))
+	d.ResetAfter = roundUp(time.Duration((capacity - b.tokens) / rate))
+
+	return d
+}
+
+func (m *memLimiter) fixedWindow(key string, n int, now time.Time) Decision {
+	w, ok := m.windows[key]
+	if !ok || !now.Before(w.expiration) {
+		w = &window{expiration: now.Add(m.limit.Period)}
+		m.windows[key] = w
+	}
+
+	d := Decision{Limit: m.limit.Rate, ResetAfter: w.expiration.Sub(now)}
+	if w.count+n <= m.limit.Rate {
+		w.count += n
+		d.Allowed = true
+	} else {
+		d.RetryAfter = d.ResetAfter
+	}
+	d.Remaining = m.limit.Rate - w.count
+
+	return d
+}
+
+func (m *memLimiter) slidingWindowLog(key string, n int, now time.Time) Decision {
+	log := m.logs[key]
+	// drop requests which left the window, the log is sorted by time
+	start := 0
+	for start < len(log) && !log[start].After(now.Add(-m.limit.Period)) {
+		start++
+	}
+	log = log[start:]
+
+	d := Decision{Limit: m.limit.Rate}
+	if len(log)+n <= m.limit.Rate {
+		for i := 0; i < n; i++ {
+			log = append(log, now)
+		}
+		d.Allowed = true
+	} else {
+		oldest := log[len(log)+n-m.limit.Rate-1]
+		d.RetryAfter = oldest.Add(m.limit.Period).Sub(now)
+	}
+	d.Remaining = m.limit.Rate - len(log)
+	if len(log) > 0 {
+		d.ResetAfter = log[len(log)-1].Add(m.limit.Period).Sub(now)
+	}
+	m.logs[key] = log
+
+	return d
+}
+
+// sweep
+// removes keys which are back to their full capacity, the caller must hold the lock
+func (m *memLimiter) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < sweepInterval {
+		return
+	}
+	m.lastSweep = now
+
+	// time which an empty bucket needs to become full
+	refill := m.limit.Period * time.Duration(m.limit.max(m.algorithm)) / time.Duration(m.limit.Rate)
+	for key, b := range m.buckets {
+		if now.Sub(b.ts) >= refill {
+			delete(m.buckets, key)
+		}
+	}
+	for key, w := range m.windows {
+		if !now.Before(w.expiration) {
+			delete(m.windows, key)
+		}
+	}
+	for key, log := range m.logs {
+		if len(log) == 0 || !log[len(log)-1].After(now.Add(-m.limit.Period)) {
+			delete(m.logs, key)
+		}
+	}
+}
+
+// roundUp rounds to milliseconds like the redis limiter does
+func roundUp(d time.Duration) time.Duration {
+	return time.Duration(math.Ceil(float64(d)/float64(time.Millisecond))) * time.Millisecond
+}
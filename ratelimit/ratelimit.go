@@ -0,0 +1,182 @@
+// Package ratelimit provides token bucket, fixed window and sliding window log rate limiters
+// which run atomically on redis or in memory of a single process.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Electronic-Catalog/microkit/metric"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Algorithm
+// decides how requests are counted
+type Algorithm string
+
+const (
+	// TokenBucket allows bursts up to Limit.Burst and refills Limit.Rate tokens in each Limit.Period
+	TokenBucket Algorithm = "token-bucket"
+	// FixedWindow allows Limit.Rate requests in each window of Limit.Period
+	FixedWindow Algorithm = "fixed-window"
+	// SlidingWindowLog allows Limit.Rate requests in any Limit.Period, it keeps a log of request times
+	SlidingWindowLog Algorithm = "sliding-window-log"
+)
+
+var (
+	ExceedsLimitError = errors.New("requested tokens exceed the limit")
+	// InvalidTokensError is returned for AllowN with n less than one, a negative n would refund quota
+	InvalidTokensError = errors.New("requested tokens must be at least one")
+)
+
+// Limit
+// Rate requests are allowed per Period, Burst is only used by TokenBucket and defaults to Rate
+type Limit struct {
+	Rate   int
+	Period time.Duration
+	Burst  int
+}
+
+func PerSecond(rate int) Limit {
+	return Limit{Rate: rate, Period: time.Second}
+}
+
+func PerMinute(rate int) Limit {
+	return Limit{Rate: rate, Period: time.Minute}
+}
+
+func PerHour(rate int) Limit {
+	return Limit{Rate: rate, Period: time.Hour}
+}
+
+// max is the number of requests which are allowed at once
+func (l Limit) max(algorithm Algorithm) int {
+	if algorithm == TokenBucket && l.Burst > 0 {
+		return l.Burst
+	}
+	return l.Rate
+}
+
+// checkTokens validates n of AllowN against the limit of algorithm
+func (l Limit) checkTokens(algorithm Algorithm, n int) error {
+	if n < 1 {
+		return InvalidTokensError
+	}
+	if n > l.max(algorithm) {
+		return ExceedsLimitError
+	}
+
+	return nil
+}
+
+func (l Limit) validate() error {
+	if l.Rate <= 0 || l.Period <= 0 {
+		return fmt.Errorf("rate and period of limit must be positive, got %d per %v", l.Rate, l.Period)
+	}
+	if l.Burst < 0 {
+		return fmt.Errorf("burst of limit can not be negative, got %d", l.Burst)
+	}
+	return nil
+}
+
+// Decision
+// the result of a limiter check, its values can be sent to clients as response headers
+type Decision struct {
+	Allowed bool
+	// Limit is the maximum number of requests (burst of token bucket)
+	Limit int
+	// Remaining is the number of requests which are allowed right now
+	Remaining int
+	// ResetAfter is the time until the limiter is back to its full capacity
+	ResetAfter time.Duration
+	// RetryAfter is the time until a denied request would be allowed, zero for allowed requests
+	RetryAfter time.Duration
+}
+
+// SetHeaders
+// writes RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset and for denied requests Retry-After
+// headers, times are in seconds and rounded up
+func (d Decision) SetHeaders(h http.Header) {
+	h.Set("RateLimit-Limit", strconv.Itoa(d.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(d.ResetAfter)))
+	if !d.Allowed {
+		h.Set("Retry-After", strconv.Itoa(ceilSeconds(d.RetryAfter)))
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// Limiter
+// keys are usually identity of the caller, e.g. "otp:user:42" or "login:ip:10.0.0.1"
+type Limiter interface {
+	// Allow
+	// to check and count one request of key
+	Allow(ctx context.Context, key string) (Decision, error)
+	// AllowN
+	// to check and count n requests of key at once, nothing is counted when they are not allowed
+	AllowN(ctx context.Context, key string, n int) (Decision, error)
+	// Reset
+	// to forget all counted requests of key, e.g. after a successful login
+	Reset(ctx context.Context, key string) error
+}
+
+type config struct {
+	prefix string
+	metric metric.Metric
+	now    func() time.Time
+}
+
+type Option func(*config)
+
+// WithPrefix
+// is prepended to keys, limiters of different endpoints sharing the same keys need different prefixes
+func WithPrefix(prefix string) Option {
+	return func(c *config) {
+		c.prefix = prefix
+	}
+}
+
+// WithMetric
+// counts allowed and denied requests of each algorithm
+func WithMetric(metric metric.Metric) Option {
+	return func(c *config) {
+		c.metric = metric
+	}
+}
+
+func newConfig(options []Option) config {
+	conf := config{
+		prefix: "ratelimit:",
+		metric: metric.NewNop(),
+		now:    time.Now,
+	}
+	for _, op := range options {
+		op(&conf)
+	}
+
+	return conf
+}
+
+func validate(algorithm Algorithm, limit Limit) error {
+	switch algorithm {
+	case TokenBucket, FixedWindow, SlidingWindowLog:
+	default:
+		return fmt.Errorf("unknown rate limit algorithm %q", algorithm)
+	}
+
+	return limit.validate()
+}
+
+func observe(m metric.Metric, algorithm Algorithm, d Decision) {
+	if d.Allowed {
+		m.IncrementTotal("ratelimit", string(algorithm), "allowed")
+	} else {
+		m.IncrementTotal("ratelimit", string(algorithm), "denied")
+	}
+}
@@ -0,0 +1,126 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/Electronic-Catalog/microkit/cache"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestLimiters(t *testing.T) {
+	srv := miniredis.RunT(t)
+	rd, err := cache.NewRedisCache(cache.WithAddresses(nil, srv.Addr()))
+	require.NoError(t, err)
+
+	limit := Limit{Rate: 3, Period: time.Millisecond * 300}
+	for _, algorithm := range []Algorithm{TokenBucket, FixedWindow, SlidingWindowLog} {
+		redisLimiter, err := NewRedisLimiter(rd, algorithm, limit, WithPrefix(string(algorithm)+":"))
+		require.NoError(t, err)
+		memLimiter, err := NewInMemoryLimiter(algorithm, limit)
+		require.NoError(t, err)
+
+		for name, limiter := range map[string]Limiter{"redis": redisLimiter, "mem": memLimiter} {
+			t.Run(string(algorithm)+"/"+name, func(t *testing.T) {
+				ctx := context.Background()
+
+				for i := 0; i < 3; i++ {
+					d, err := limiter.Allow(ctx, "login:ip:10.0.0.1")
+					require.NoError(t, err)
+					require.True(t, d.Allowed)
+					require.Equal(t, 3, d.Limit)
+					require.Equal(t, 2-i, d.Remaining)
+					require.Zero(t, d.RetryAfter)
+					require.Greater(t, d.ResetAfter, time.Duration(0))
+				}
+
+				d, err := limiter.Allow(ctx, "login:ip:10.0.0.1")
+				require.NoError(t, err)
+				require.False(t, d.Allowed)
+				require.Equal(t, 0, d.Remaining)
+				require.Greater(t, d.RetryAfter, time.Duration(0))
+				require.LessOrEqual(t, d.RetryAfter, limit.Period)
+
+				// other keys have their own limit
+				d, err = limiter.Allow(ctx, "login:ip:10.0.0.2")
+				require.NoError(t, err)
+				require.True(t, d.Allowed)
+
+				time.Sleep(limit.Period + time.Millisecond*20)
+				// miniredis only expires keys when its clock is moved
+				srv.FastForward(limit.Period + time.Millisecond*20)
+				d, err = limiter.Allow(ctx, "login:ip:10.0.0.1")
+				require.NoError(t, err)
+				require.True(t, d.Allowed)
+
+				require.NoError(t, limiter.Reset(ctx, "login:ip:10.0.0.1"))
+				d, err = limiter.AllowN(ctx, "login:ip:10.0.0.1", 3)
+				require.NoError(t, err)
+				require.True(t, d.Allowed)
+
+				_, err = limiter.AllowN(ctx, "login:ip:10.0.0.1", 4)
+				require.ErrorIs(t, err, ExceedsLimitError)
+
+				// zero or negative tokens would refund quota
+				for _, n := range []int{0, -3} {
+					_, err = limiter.AllowN(ctx, "login:ip:10.0.0.1", n)
+					require.ErrorIs(t, err, InvalidTokensError)
+				}
+				d, err = limiter.Allow(ctx, "login:ip:10.0.0.1")
+				require.NoError(t, err)
+				require.False(t, d.Allowed)
+			})
+		}
+	}
+}
+
+func TestTokenBucketBurst(t *testing.T) {
+	limiter, err := NewInMemoryLimiter(TokenBucket, Limit{Rate: 1, Period: time.Second, Burst: 5})
+	require.NoError(t, err)
+	ml := limiter.(*memLimiter)
+	now := time.Now()
+	ml.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	d, err := limiter.AllowN(ctx, "otp:user:42", 5)
+	require.NoError(t, err)
+	require.True(t, d.Allowed)
+	require.Equal(t, 5*time.Second, d.ResetAfter)
+
+	d, err = limiter.Allow(ctx, "otp:user:42")
+	require.NoError(t, err)
+	require.False(t, d.Allowed)
+	require.Equal(t, time.Second, d.RetryAfter)
+
+	// one token is refilled per second
+	now = now.Add(time.Second * 2)
+	d, err = limiter.AllowN(ctx, "otp:user:42", 2)
+	require.NoError(t, err)
+	require.True(t, d.Allowed)
+	require.Equal(t, 0, d.Remaining)
+}
+
+func TestInvalidLimit(t *testing.T) {
+	_, err := NewInMemoryLimiter(TokenBucket, Limit{Rate: 0, Period: time.Second})
+	require.Error(t, err)
+	_, err = NewInMemoryLimiter("leaky", PerSecond(1))
+	require.Error(t, err)
+
+	mem, err := cache.NewInMemoryCache(time.Second)
+	require.NoError(t, err)
+	defer mem.Close()
+	_, err = NewRedisLimiter(mem, TokenBucket, PerSecond(1))
+	require.Error(t, err)
+}
+
+func TestDecisionHeaders(t *testing.T) {
+	h := http.Header{}
+	Decision{Allowed: false, Limit: 5, Remaining: 0, ResetAfter: time.Millisecond * 1500, RetryAfter: time.Millisecond * 200}.SetHeaders(h)
+
+	require.Equal(t, "5", h.Get("RateLimit-Limit"))
+	require.Equal(t, "0", h.Get("RateLimit-Remaining"))
+	require.Equal(t, "2", h.Get("RateLimit-Reset"))
+	require.Equal(t, "1", h.Get("Retry-After"))
+}
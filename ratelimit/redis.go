@@ -0,0 +1,190 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/Electronic-Catalog/microkit/cache"
+	"github.com/Electronic-Catalog/microkit/metric"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"strconv"
+	"time"
+)
+
+// times are passed to scripts in milliseconds by the client, so all of them share the same clock source
+
+// tokenBucketScript
+// KEYS[1] bucket, ARGV capacity, refill rate per millisecond, now, requested tokens
+var tokenBucketScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local requested = tonumber(ARGV[4])
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(state[1]) or capacity
+local ts = tonumber(state[2]) or now
+if now > ts then
+	tokens = math.min(capacity, tokens + (now - ts) * rate)
+end
+
+local allowed = 0
+local retry = 0
+if tokens >= requested then
+	tokens = tokens - requested
+	allowed = 1
+else
+	retry = math.ceil((requested - tokens) / rate)
+end
+
+local reset = math.ceil((capacity - tokens) / rate)
+-- a replica with a clock behind the others must not move the bucket back in time
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", tostring(math.max(ts, now)))
+redis.call("PEXPIRE", KEYS[1], math.max(reset, 1))
+
+return {allowed, math.floor(tokens), reset, retry}
+`)
+
+// fixedWindowScript
+// KEYS[1] counter, ARGV window in milliseconds, limit, requested count
+var fixedWindowScript = redis.NewScript(`
+local window = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+local requested = tonumber(ARGV[3])
+
+local current = tonumber(redis.call("GET", KEYS[1]) or "0")
+local allowed = 0
+if current + requested <= limit then
+	current = redis.call("INCRBY", KEYS[1], requested)
+	allowed = 1
+end
+
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl < 0 then
+	redis.call("PEXPIRE", KEYS[1], window)
+	ttl = window
+end
+
+local retry = 0
+if allowed == 0 then
+	retry = ttl
+end
+
+return {allowed, limit - current, ttl, retry}
+`)
+
+// slidingWindowLogScript
+// KEYS[1] sorted set of request times, ARGV window in milliseconds, limit, requested count, now, unique id
+var slidingWindowLogScript = redis.NewScript(`
+local window = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+local requested = tonumber(ARGV[3])
+local now = tonumber(ARGV[4])
+
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now - window)
+local count = redis.call("ZCARD", KEYS[1])
+
+local allowed = 0
+local retry = 0
+if count + requested <= limit then
+	for i = 1, requested do
+		redis.call("ZADD", KEYS[1], now, ARGV[5] .. ":" .. i)
+	end
+	count = count + requested
+	allowed = 1
+else
+	-- the request is allowed when enough of the oldest requests leave the window
+	local idx = count + requested - limit - 1
+	local entry = redis.call("ZRANGE", KEYS[1], idx, idx, "WITHSCORES")
+	retry = tonumber(entry[2]) + window - now
+end
+
+local reset = 0
+local newest = redis.call("ZRANGE", KEYS[1], -1, -1, "WITHSCORES")
+if newest[2] then
+	reset = tonumber(newest[2]) + window - now
+	redis.call("PEXPIRE", KEYS[1], reset)
+end
+
+return {allowed, limit - count, reset, retry}
+`)
+
+type redisLimiter struct {
+	client    redis.UniversalClient
+	algorithm Algorithm
+	limit     Limit
+	prefix    string
+	metric    metric.Metric
+	now       func() time.Time
+}
+
+// NewRedisLimiter
+// runs the limiter atomically on redis, it shares connections of c which has to be created by cache.NewRedisCache,
+// so every replica of a service sees the same counters
+func NewRedisLimiter(c cache.Cache, algorithm Algorithm, limit Limit, options ...Option) (Limiter, error) {
+	err := validate(algorithm, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := cache.RedisClient(c)
+	if err != nil {
+		return nil, err
+	}
+
+	conf := newConfig(options)
+
+	return &redisLimiter{
+		client:    client,
+		algorithm: algorithm,
+		limit:     limit,
+		prefix:    conf.prefix,
+		metric:    conf.metric,
+		now:       conf.now,
+	}, nil
+}
+
+func (r *redisLimiter) Allow(ctx context.Context, key string) (Decision, error) {
+	return r.AllowN(ctx, key, 1)
+}
+
+func (r *redisLimiter) AllowN(ctx context.Context, key string, n int) (Decision, error) {
+	if err := r.limit.checkTokens(r.algorithm, n); err != nil {
+		return Decision{}, err
+	}
+
+	now := r.now().UnixMilli()
+	period := r.limit.Period.Milliseconds()
+	keys := []string{r.prefix + key}
+
+	var res []interface{}
+	var err error
+	switch r.algorithm {
+	case TokenBucket:
+		rate := float64(r.limit.Rate) / float64(period)
+		res, err = tokenBucketScript.Run(ctx, r.client, keys,
+			r.limit.max(r.algorithm), strconv.FormatFloat(rate, 'f', -1, 64), now, n).Slice()
+	case FixedWindow:
+		res, err = fixedWindowScript.Run(ctx, r.client, keys, period, r.limit.Rate, n).Slice()
+	case SlidingWindowLog:
+		res, err = slidingWindowLogScript.Run(ctx, r.client, keys,
+			period, r.limit.Rate, n, now, uuid.New().String()).Slice()
+	}
+	if err != nil {
+		return Decision{}, err
+	}
+
+	d := Decision{
+		Allowed:    res[0].(int64) == 1,
+		Limit:      r.limit.max(r.algorithm),
+		Remaining:  int(res[1].(int64)),
+		ResetAfter: time.Duration(res[2].(int64)) * time.Millisecond,
+		RetryAfter: time.Duration(res[3].(int64)) * time.Millisecond,
+	}
+	observe(r.metric, r.algorithm, d)
+
+	return d, nil
+}
+
+func (r *redisLimiter) Reset(ctx context.Context, key string) error {
+	return r.client.Del(ctx, r.prefix+key).Err()
+}